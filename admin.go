@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type createUserPayload struct {
+	Name string `json:"name"`
+	Role string `json:"role"`
+}
+
+func (a *app) createUser(c echo.Context) error {
+	var payload createUserPayload
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	if payload.Name == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "missing name"})
+	}
+	if payload.Role == "" {
+		payload.Role = database.RoleUser
+	}
+	if payload.Role != database.RoleUser && payload.Role != database.RoleAdmin {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid role"})
+	}
+
+	user := database.User{
+		ID:        database.NewObjectID(),
+		Name:      payload.Name,
+		Role:      payload.Role,
+		CreatedAt: time.Now(),
+	}
+	if _, err := a.colUser.InsertOne(c.Request().Context(), user); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"user": user})
+}
+
+func (a *app) users(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	users := make([]database.User, 0)
+	cursor, err := a.colUser.Find(ctx, bson.D{}, &options.FindOptions{Sort: bson.M{"_id": -1}})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if err = cursor.All(ctx, &users); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"users": users})
+}
+
+type issueAPIKeyPayload struct {
+	Name string `json:"name"`
+}
+
+// issueAPIKey returns the plain key, it cannot be retrieved again
+func (a *app) issueAPIKey(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := database.ObjectIDFromString(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid user id"})
+	}
+
+	var payload issueAPIKeyPayload
+	if err = c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	if err = a.colUser.FindOne(ctx, bson.M{"_id": userID}).Err(); errors.Is(err, mongo.ErrNoDocuments) {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "user not found"})
+	} else if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	key, hash, err := newAPIKey()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"message": err.Error()})
+	}
+
+	apiKey := database.APIKey{
+		ID:        database.NewObjectID(),
+		UserID:    userID,
+		Name:      payload.Name,
+		Prefix:    key[:len(apiKeyPrefix)+6],
+		Hash:      hash,
+		CreatedAt: time.Now(),
+	}
+	if _, err = a.colAPIKey.InsertOne(ctx, apiKey); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"apiKey": apiKey, "key": key})
+}
+
+func (a *app) apiKeys(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	userID, err := database.ObjectIDFromString(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid user id"})
+	}
+
+	keys := make([]database.APIKey, 0)
+	cursor, err := a.colAPIKey.Find(ctx, bson.M{"userId": userID}, &options.FindOptions{Sort: bson.M{"_id": -1}})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if err = cursor.All(ctx, &keys); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"apiKeys": keys})
+}
+
+func (a *app) revokeAPIKey(c echo.Context) error {
+	id, err := database.ObjectIDFromString(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid api key id"})
+	}
+
+	res, err := a.colAPIKey.UpdateOne(c.Request().Context(),
+		bson.M{"_id": id, "revokedAt": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"revokedAt": time.Now()}},
+	)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if res.MatchedCount == 0 {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "api key not found"})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{})
+}
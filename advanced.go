@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/stablediffusion"
+	"github.com/namhq1989/demo-ai/style"
+	oai "github.com/sashabaranov/go-openai"
+)
+
+// bounds of the advanced parameters
+const (
+	minSteps             = 1
+	maxSteps             = 50
+	minCFGScale          = 1
+	maxCFGScale          = 20
+	maxNegativePromptLen = 1000
+)
+
+// advancedParams overrides the style preset per provider, every field is optional
+type advancedParams struct {
+	Prodia          *prodiaParams    `json:"prodia,omitempty"`
+	OpenAI          *openAIParams    `json:"openai,omitempty"`
+	StableDiffusion *stabilityParams `json:"stable-diffusion,omitempty"`
+}
+
+type prodiaParams struct {
+	Model          string `json:"model"`
+	Sampler        string `json:"sampler"`
+	Steps          int    `json:"steps"`
+	CFGScale       int    `json:"cfgScale"`
+	NegativePrompt string `json:"negativePrompt"`
+}
+
+type openAIParams struct {
+	Quality string `json:"quality"`
+	Style   string `json:"style"`
+}
+
+type stabilityParams struct {
+	Model          string `json:"model"`
+	NegativePrompt string `json:"negativePrompt"`
+}
+
+// UnmarshalParam binds the "advanced" field of a multipart request, a JSON object
+func (p *advancedParams) UnmarshalParam(s string) error {
+	if err := json.Unmarshal([]byte(s), p); err != nil {
+		return errors.New("invalid advanced params")
+	}
+	return nil
+}
+
+// parseAdvancedParams parses the "advanced" query param, a JSON object
+func parseAdvancedParams(s string) (advancedParams, error) {
+	var params advancedParams
+	if s == "" {
+		return params, nil
+	}
+	if err := json.Unmarshal([]byte(s), &params); err != nil {
+		return params, errors.New("invalid advanced params")
+	}
+	return params, params.validate()
+}
+
+// validate checks the bounds, the Prodia model and sampler are checked against the catalog when the job is submitted
+func (p advancedParams) validate() error {
+	if p.Prodia != nil {
+		if p.Prodia.Steps != 0 && (p.Prodia.Steps < minSteps || p.Prodia.Steps > maxSteps) {
+			return fmt.Errorf("prodia steps must be between %d and %d", minSteps, maxSteps)
+		}
+		if p.Prodia.CFGScale != 0 && (p.Prodia.CFGScale < minCFGScale || p.Prodia.CFGScale > maxCFGScale) {
+			return fmt.Errorf("prodia cfgScale must be between %d and %d", minCFGScale, maxCFGScale)
+		}
+		if len(p.Prodia.NegativePrompt) > maxNegativePromptLen {
+			return fmt.Errorf("prodia negativePrompt must be at most %d characters", maxNegativePromptLen)
+		}
+	}
+
+	if p.OpenAI != nil {
+		if p.OpenAI.Quality != "" && p.OpenAI.Quality != oai.CreateImageQualityStandard && p.OpenAI.Quality != oai.CreateImageQualityHD {
+			return errors.New("openai quality must be standard or hd")
+		}
+		if p.OpenAI.Style != "" && p.OpenAI.Style != oai.CreateImageStyleVivid && p.OpenAI.Style != oai.CreateImageStyleNatural {
+			return errors.New("openai style must be vivid or natural")
+		}
+	}
+
+	if p.StableDiffusion != nil {
+		if p.StableDiffusion.Model != "" && !slices.Contains(stablediffusion.Models, p.StableDiffusion.Model) {
+			return fmt.Errorf("stable-diffusion model must be one of %v", stablediffusion.Models)
+		}
+		if len(p.StableDiffusion.NegativePrompt) > maxNegativePromptLen {
+			return fmt.Errorf("stable-diffusion negativePrompt must be at most %d characters", maxNegativePromptLen)
+		}
+	}
+
+	return nil
+}
+
+// prodia returns the parameters of the preset overridden by the request
+func (p advancedParams) prodia(preset style.Preset) database.ProdiaConfiguration {
+	params := database.ProdiaConfiguration{
+		Model:          preset.Prodia.Model,
+		Sampler:        preset.Prodia.Sampler,
+		Steps:          preset.Prodia.Steps,
+		CFGScale:       preset.Prodia.CFGScale,
+		NegativePrompt: preset.NegativePrompt,
+	}
+	if o := p.Prodia; o != nil {
+		params.Model = valueOr(o.Model, params.Model)
+		params.Sampler = valueOr(o.Sampler, params.Sampler)
+		params.Steps = valueOr(o.Steps, params.Steps)
+		params.CFGScale = valueOr(o.CFGScale, params.CFGScale)
+		params.NegativePrompt = valueOr(o.NegativePrompt, params.NegativePrompt)
+	}
+	return params
+}
+
+func (p advancedParams) openAI(preset style.Preset) database.OpenAIConfiguration {
+	params := database.OpenAIConfiguration{
+		Model:   oai.CreateImageModelDallE3,
+		Quality: oai.CreateImageQualityStandard,
+		Style:   preset.DallE.Style,
+	}
+	if o := p.OpenAI; o != nil {
+		params.Quality = valueOr(o.Quality, params.Quality)
+		params.Style = valueOr(o.Style, params.Style)
+	}
+	return params
+}
+
+func (p advancedParams) stableDiffusion(preset style.Preset) database.StabilityConfiguration {
+	params := database.StabilityConfiguration{
+		Model:          preset.Stability.Model,
+		NegativePrompt: preset.NegativePrompt,
+	}
+	if o := p.StableDiffusion; o != nil {
+		params.Model = valueOr(o.Model, params.Model)
+		params.NegativePrompt = valueOr(o.NegativePrompt, params.NegativePrompt)
+	}
+
+	// SD3 Turbo does not support negative prompts
+	if params.Model == stablediffusion.ModelSD3Turbo {
+		params.NegativePrompt = ""
+	}
+	return params
+}
+
+func valueOr[T comparable](value, fallback T) T {
+	var zero T
+	if value == zero {
+		return fallback
+	}
+	return value
+}
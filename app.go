@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/namhq1989/demo-ai/breaker"
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/openai"
+	"github.com/namhq1989/demo-ai/prodia"
+	"github.com/namhq1989/demo-ai/ratelimit"
+	"github.com/namhq1989/demo-ai/stablediffusion"
+	"github.com/namhq1989/demo-ai/style"
+	"github.com/namhq1989/demo-ai/throttle"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	providerStableDiffusion = "stable-diffusion"
+	providerOpenAI          = "openai"
+	providerProdia          = "prodia"
+)
+
+// app holds the dependencies shared by the handlers
+type app struct {
+	sd          stablediffusion.StableDiffusion
+	oa          *openai.OpenAI
+	pd          prodia.Prodia
+	pdJobs      prodiaJobRunner
+	historyRepo database.HistoryRepository
+	colUser     *mongo.Collection
+	colAPIKey   *mongo.Collection
+	colImage    *mongo.Collection
+
+	// colHistory backs the cost reports, which aggregate in MongoDB
+	colHistory *mongo.Collection
+
+	colCreditAccount *mongo.Collection
+	colCreditEntry   *mongo.Collection
+
+	colFeedback         *mongo.Collection
+	colGenerationWinner *mongo.Collection
+
+	// breakers guard every provider, failover maps a provider to the one used while its breaker is open
+	breakers *breaker.Registry
+	failover map[string]string
+
+	// throttles keep the calls of every vendor under its concurrency and rate limits
+	throttles map[string]*throttle.Throttle
+
+	styles *style.Registry
+
+	uploads  uploadConfig
+	previews previewConfig
+
+	// rateLimitStore is the shared rate limit store, nil when the limits are kept in process
+	rateLimitStore *ratelimit.FallbackStore
+
+	budget budgetConfig
+	auth   authConfig
+	credit creditConfig
+}
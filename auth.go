@@ -0,0 +1,166 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// apiKeyPrefix tells API keys and JWTs apart in the Authorization header
+const apiKeyPrefix = "dai_"
+
+const ctxKeyCaller = "caller"
+
+type authConfig struct {
+	Enabled bool
+
+	// AdminAPIKey is a static key with the admin role, used to issue the first keys
+	AdminAPIKey string
+
+	// JWTSecret verifies HS256 tokens, "sub" is the user id, "role" the role and "exp" is required
+	JWTSecret string
+}
+
+// caller is the authenticated user of the request, ID is empty when auth is disabled
+type caller struct {
+	ID   string
+	Role string
+}
+
+func (c caller) isAdmin() bool {
+	return c.Role == database.RoleAdmin
+}
+
+func getCaller(c echo.Context) caller {
+	if v, ok := c.Get(ctxKeyCaller).(caller); ok {
+		return v
+	}
+	return caller{}
+}
+
+// authenticate accepts "Authorization: Bearer <api key or jwt>" or "X-API-Key: <api key>"
+func (a *app) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !a.auth.Enabled {
+			return next(c)
+		}
+
+		token := c.Request().Header.Get("X-API-Key")
+		if token == "" {
+			token, _ = strings.CutPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		}
+		if token == "" {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"message": "missing credentials"})
+		}
+
+		var (
+			user caller
+			err  error
+		)
+		switch {
+		case a.isAdminAPIKey(token):
+			user = caller{ID: "admin", Role: database.RoleAdmin}
+		case strings.HasPrefix(token, apiKeyPrefix):
+			user, err = a.callerFromAPIKey(c, token)
+		default:
+			user, err = a.callerFromJWT(token)
+		}
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, echo.Map{"message": err.Error()})
+		}
+
+		c.Set(ctxKeyCaller, user)
+		return next(c)
+	}
+}
+
+// requireAdmin lets only admins through, without auth there is no admin so the route is closed
+func (a *app) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !a.auth.Enabled {
+			return c.JSON(http.StatusForbidden, echo.Map{"message": "admin only, auth is disabled"})
+		}
+		if !getCaller(c).isAdmin() {
+			return c.JSON(http.StatusForbidden, echo.Map{"message": "admin only"})
+		}
+		return next(c)
+	}
+}
+
+// isAdminAPIKey compares the token to the static admin key, whatever its format
+func (a *app) isAdminAPIKey(token string) bool {
+	return a.auth.AdminAPIKey != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.auth.AdminAPIKey)) == 1
+}
+
+func (a *app) callerFromAPIKey(c echo.Context, key string) (caller, error) {
+	ctx := c.Request().Context()
+
+	var apiKey database.APIKey
+	err := a.colAPIKey.FindOne(ctx, bson.M{"hash": hashAPIKey(key), "revokedAt": bson.M{"$exists": false}}).Decode(&apiKey)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return caller{}, errors.New("invalid api key")
+	}
+	if err != nil {
+		return caller{}, err
+	}
+
+	var user database.User
+	if err = a.colUser.FindOne(ctx, bson.M{"_id": apiKey.UserID}).Decode(&user); err != nil {
+		return caller{}, errors.New("invalid api key")
+	}
+
+	return caller{ID: user.ID.Hex(), Role: user.Role}, nil
+}
+
+func (a *app) callerFromJWT(tokenString string) (caller, error) {
+	if a.auth.JWTSecret == "" {
+		return caller{}, errors.New("invalid token")
+	}
+
+	// tokens without "exp" would never expire
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return []byte(a.auth.JWTSecret), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}), jwt.WithExpirationRequired())
+	if err != nil {
+		return caller{}, errors.New("invalid token")
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return caller{}, errors.New("invalid token")
+	}
+
+	role, _ := claims["role"].(string)
+	if role != database.RoleAdmin {
+		role = database.RoleUser
+	}
+
+	return caller{ID: sub, Role: role}, nil
+}
+
+// newAPIKey returns a random key and its hash
+func newAPIKey() (string, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+
+	key := apiKeyPrefix + hex.EncodeToString(b)
+	return key, hashAPIKey(key), nil
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,253 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	StateClosed   = "closed"
+	StateOpen     = "open"
+	StateHalfOpen = "half-open"
+)
+
+var ErrOpen = errors.New("circuit breaker is open")
+
+type Config struct {
+	// WindowSize is the number of latest calls used to compute the error rate
+	WindowSize int
+
+	// MinRequests is the number of calls in the window required before the breaker can open
+	MinRequests int
+
+	// FailureRate opens the breaker when the ratio of failed calls in the window reaches it
+	FailureRate float64
+
+	// SlowThreshold counts a successful call as failed when it takes longer, 0 disables it
+	SlowThreshold time.Duration
+
+	// OpenTimeout is how long the breaker stays open before letting a trial call through
+	OpenTimeout time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		WindowSize:    20,
+		MinRequests:   5,
+		FailureRate:   0.5,
+		SlowThreshold: 0,
+		OpenTimeout:   30 * time.Second,
+	}
+}
+
+type Breaker struct {
+	name string
+	cfg  Config
+
+	mu        sync.Mutex
+	state     string
+	openedAt  time.Time
+	probing   bool
+	results   []result
+	next      int
+	latencies time.Duration
+
+	// generation is bumped on every change of state, the results of the calls let through before are dropped
+	generation uint64
+}
+
+// token is handed to every call let through, only the call holding the probe decides the half-open state
+type token struct {
+	probe      bool
+	generation uint64
+}
+
+type result struct {
+	failed  bool
+	latency time.Duration
+}
+
+// Status is the snapshot of a breaker, exposed on the health endpoint
+type Status struct {
+	Name       string    `json:"name"`
+	State      string    `json:"state"`
+	Requests   int       `json:"requests"`
+	Failures   int       `json:"failures"`
+	ErrorRate  float64   `json:"errorRate"`
+	AvgLatency string    `json:"avgLatency"`
+	OpenedAt   time.Time `json:"openedAt,omitempty"`
+}
+
+func New(name string, cfg Config) *Breaker {
+	return &Breaker{
+		name:    name,
+		cfg:     cfg,
+		state:   StateClosed,
+		results: make([]result, 0, cfg.WindowSize),
+	}
+}
+
+// Do runs fn unless the breaker is open, the outcome and latency of fn are recorded
+func (b *Breaker) Do(fn func() error) error {
+	done, err := b.Start()
+	if err != nil {
+		return err
+	}
+
+	err = fn()
+	done(err)
+
+	return err
+}
+
+// Start lets a call through unless the breaker is open, done must be called once with the outcome of the call.
+// It is Do for the calls which do not fit in one function
+func (b *Breaker) Start() (done func(err error), err error) {
+	t, err := b.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	return func(err error) { b.record(t, err, time.Since(start)) }, nil
+}
+
+// Allowed reports whether a call would be let through right now
+func (b *Breaker) Allowed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == StateOpen {
+		return time.Since(b.openedAt) >= b.cfg.OpenTimeout
+	}
+	return b.state == StateClosed || !b.probing
+}
+
+func (b *Breaker) allow() (token, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case StateOpen:
+		if time.Since(b.openedAt) < b.cfg.OpenTimeout {
+			return token{}, ErrOpen
+		}
+		b.state = StateHalfOpen
+		b.generation++
+		b.probing = true
+		return token{probe: true, generation: b.generation}, nil
+	case StateHalfOpen:
+		// only one trial call at a time
+		if b.probing {
+			return token{}, ErrOpen
+		}
+		b.probing = true
+		return token{probe: true, generation: b.generation}, nil
+	}
+
+	return token{generation: b.generation}, nil
+}
+
+func (b *Breaker) record(t token, err error, latency time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// the caller gave up, it says nothing about the provider
+	if errors.Is(err, context.Canceled) {
+		if t.probe {
+			b.probing = false
+		}
+		return
+	}
+
+	failed := err != nil || (b.cfg.SlowThreshold > 0 && latency > b.cfg.SlowThreshold)
+
+	if t.probe {
+		b.probing = false
+		if failed {
+			b.open()
+			return
+		}
+		b.reset()
+		b.state = StateClosed
+		b.generation++
+	} else if t.generation != b.generation {
+		// the call started before the breaker opened, the probe decides from now on
+		return
+	}
+
+	b.push(result{failed: failed, latency: latency})
+
+	if len(b.results) >= b.cfg.MinRequests && b.errorRate() >= b.cfg.FailureRate {
+		b.open()
+	}
+}
+
+func (b *Breaker) push(r result) {
+	if b.cfg.WindowSize <= 0 {
+		return
+	}
+
+	if len(b.results) < b.cfg.WindowSize {
+		b.results = append(b.results, r)
+	} else {
+		b.latencies -= b.results[b.next].latency
+		b.results[b.next] = r
+		b.next = (b.next + 1) % b.cfg.WindowSize
+	}
+	b.latencies += r.latency
+}
+
+func (b *Breaker) open() {
+	b.state = StateOpen
+	b.openedAt = time.Now()
+	b.generation++
+}
+
+func (b *Breaker) reset() {
+	b.results = b.results[:0]
+	b.next = 0
+	b.latencies = 0
+}
+
+func (b *Breaker) failures() int {
+	n := 0
+	for _, r := range b.results {
+		if r.failed {
+			n++
+		}
+	}
+	return n
+}
+
+func (b *Breaker) errorRate() float64 {
+	if len(b.results) == 0 {
+		return 0
+	}
+	return float64(b.failures()) / float64(len(b.results))
+}
+
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var avg time.Duration
+	if len(b.results) > 0 {
+		avg = b.latencies / time.Duration(len(b.results))
+	}
+
+	s := Status{
+		Name:       b.name,
+		State:      b.state,
+		Requests:   len(b.results),
+		Failures:   b.failures(),
+		ErrorRate:  b.errorRate(),
+		AvgLatency: avg.String(),
+	}
+	if b.state != StateClosed {
+		s.OpenedAt = b.openedAt
+	}
+	return s
+}
@@ -0,0 +1,53 @@
+package breaker
+
+import (
+	"sort"
+	"sync"
+)
+
+// Registry holds one breaker per provider
+type Registry struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	breakers map[string]*Breaker
+}
+
+func NewRegistry(cfg Config) *Registry {
+	return &Registry{
+		cfg:      cfg,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Get returns the breaker of the provider, it is created on first use
+func (r *Registry) Get(name string) *Breaker {
+	r.mu.RLock()
+	b, ok := r.breakers[name]
+	r.mu.RUnlock()
+	if ok {
+		return b
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if b, ok = r.breakers[name]; !ok {
+		b = New(name, r.cfg)
+		r.breakers[name] = b
+	}
+	return b
+}
+
+func (r *Registry) Statuses() []Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	statuses := make([]Status, 0, len(r.breakers))
+	for _, b := range r.breakers {
+		statuses = append(statuses, b.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	return statuses
+}
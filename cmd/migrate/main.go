@@ -0,0 +1,26 @@
+// Command migrate rewrites the records of older schema versions, it is safe to run several times.
+// It reads MONGO_URL and MONGO_DB_NAME
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/namhq1989/demo-ai/database"
+)
+
+func main() {
+	ctx := context.Background()
+
+	client := database.NewMongoClient(os.Getenv("MONGO_URL"))
+	defer func() { _ = client.Disconnect(ctx) }()
+
+	migrated, err := database.MigrateHistories(ctx, client.Database(os.Getenv("MONGO_DB_NAME")))
+	if err != nil {
+		fmt.Println("[MIGRATE] error when migrating histories:", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("[MIGRATE] %d records migrated to schema version %d \n", migrated, database.HistorySchemaVersion)
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type (
@@ -12,9 +13,66 @@ type (
 		StableDiffusionAPIKey string
 		ProdiaAPIKey          string
 
+		// Prodia jobs
+		ProdiaPersistJobs    bool
+		ProdiaPollMaxWaitSec int
+		ProdiaCatalogTTLSec  int
+
+		// Circuit breaker, ProviderFailover maps a provider to its alternative, e.g. "stable-diffusion=prodia"
+		BreakerSlowThresholdSec int
+		ProviderFailover        map[string]string
+
+		// Budget caps in USD per day, 0 means no cap
+		BudgetDaily            float64
+		BudgetDailyPerUser     float64
+		BudgetDailyPerProvider map[string]float64
+		BudgetDowngrade        bool
+
+		// Vendor limits, "provider=concurrency:rpm"
+		VendorLimits map[string]string
+
+		// StylePresetsFile replaces the bundled style presets, see style/presets.json
+		StylePresetsFile string
+
 		// MongoDB
 		MongoURL    string
 		MongoDBName string
+
+		// Auth
+		AuthEnabled bool
+		AdminAPIKey string
+		JWTSecret   string
+
+		// Credits
+		FreeCredits    int
+		FreeDallEDaily int
+
+		// Rate limit, "mongo" shares the counters between replicas, "memory" keeps them in process
+		RateLimitStore     string
+		RateLimitExpensive int
+		RateLimitCheap     int
+		RateLimitGlobal    int
+		RateLimitWindowSec int
+
+		// Input images, larger images are rejected
+		ImageMaxBytes     int
+		ImageMaxDimension int
+
+		// ImageVariantCacheMB caps the cache of the resized and converted images
+		ImageVariantCacheMB int
+
+		// Previews are watermarked with the logo, or the text, "tiled" or "corner", WATERMARK_TEXT="" disables the watermark.
+		// The clean images are downloaded with urls signed by ImageURLSecret
+		WatermarkText       string
+		WatermarkLogoFile   string
+		WatermarkMode       string
+		WatermarkOpacity    float64
+		ImageURLSecret      string
+		ImageDownloadTTLSec int
+
+		// Deleted histories keep their images for the retention window, then the images are swept
+		HistoryRetentionHours    int
+		HistorySweepIntervalMins int
 	}
 )
 
@@ -23,9 +81,53 @@ func initConfig() Server {
 		MongoURL:    getEnvStr("MONGO_URL"),
 		MongoDBName: getEnvStr("MONGO_DB_NAME"),
 
+		AuthEnabled: getEnvBool("AUTH_ENABLED"),
+		AdminAPIKey: getEnvStr("ADMIN_API_KEY"),
+		JWTSecret:   getEnvStr("JWT_SECRET"),
+
+		FreeCredits:    getEnvInt("FREE_CREDITS"),
+		FreeDallEDaily: getEnvInt("FREE_DALLE_DAILY"),
+
+		RateLimitStore:     getEnvStr("RATE_LIMIT_STORE"),
+		RateLimitExpensive: getEnvInt("RATE_LIMIT_EXPENSIVE"),
+		RateLimitCheap:     getEnvInt("RATE_LIMIT_CHEAP"),
+		RateLimitGlobal:    getEnvInt("RATE_LIMIT_GLOBAL"),
+		RateLimitWindowSec: getEnvInt("RATE_LIMIT_WINDOW_SEC"),
+
 		OpenAIToken:           getEnvStr("OPENAI_TOKEN"),
 		StableDiffusionAPIKey: getEnvStr("STABLE_DIFFUSION_API_KEY"),
 		ProdiaAPIKey:          getEnvStr("PRODIA_API_KEY"),
+
+		ProdiaPersistJobs:    getEnvBool("PRODIA_PERSIST_JOBS"),
+		ProdiaPollMaxWaitSec: getEnvInt("PRODIA_POLL_MAX_WAIT_SEC"),
+		ProdiaCatalogTTLSec:  getEnvInt("PRODIA_CATALOG_TTL_SEC"),
+
+		BreakerSlowThresholdSec: getEnvInt("BREAKER_SLOW_THRESHOLD_SEC"),
+		ProviderFailover:        getEnvMap("PROVIDER_FAILOVER"),
+
+		BudgetDaily:            getEnvFloat("BUDGET_DAILY"),
+		BudgetDailyPerUser:     getEnvFloat("BUDGET_DAILY_PER_USER"),
+		BudgetDailyPerProvider: getEnvFloatMap("BUDGET_DAILY_PER_PROVIDER"),
+		BudgetDowngrade:        getEnvBool("BUDGET_DOWNGRADE"),
+
+		VendorLimits: getEnvMap("VENDOR_LIMITS"),
+
+		StylePresetsFile: getEnvStr("STYLE_PRESETS_FILE"),
+
+		ImageMaxBytes:     getEnvInt("IMAGE_MAX_BYTES"),
+		ImageMaxDimension: getEnvInt("IMAGE_MAX_DIMENSION"),
+
+		ImageVariantCacheMB: getEnvInt("IMAGE_VARIANT_CACHE_MB"),
+
+		WatermarkText:       os.Getenv("WATERMARK_TEXT"),
+		WatermarkLogoFile:   getEnvStr("WATERMARK_LOGO_FILE"),
+		WatermarkMode:       getEnvStr("WATERMARK_MODE"),
+		WatermarkOpacity:    getEnvFloat("WATERMARK_OPACITY"),
+		ImageURLSecret:      getEnvStr("IMAGE_URL_SECRET"),
+		ImageDownloadTTLSec: getEnvInt("IMAGE_DOWNLOAD_TTL_SEC"),
+
+		HistoryRetentionHours:    getEnvInt("HISTORY_RETENTION_HOURS"),
+		HistorySweepIntervalMins: getEnvInt("HISTORY_SWEEP_INTERVAL_MINS"),
 	}
 
 	// validation
@@ -41,6 +143,51 @@ func initConfig() Server {
 		panic(errors.New("missing ProdiaAPIKey"))
 	}
 
+	// default limits
+	if cfg.RateLimitExpensive <= 0 {
+		cfg.RateLimitExpensive = 10
+	}
+	if cfg.RateLimitCheap <= 0 {
+		cfg.RateLimitCheap = 120
+	}
+	if cfg.RateLimitGlobal <= 0 {
+		cfg.RateLimitGlobal = 600
+	}
+	if cfg.RateLimitWindowSec <= 0 {
+		cfg.RateLimitWindowSec = 60
+	}
+
+	if cfg.ImageMaxBytes <= 0 {
+		cfg.ImageMaxBytes = 10 << 20
+	}
+	if cfg.ImageMaxDimension <= 0 {
+		cfg.ImageMaxDimension = 4096
+	}
+	if cfg.ImageVariantCacheMB <= 0 {
+		cfg.ImageVariantCacheMB = 1024
+	}
+
+	if _, ok := os.LookupEnv("WATERMARK_TEXT"); !ok {
+		cfg.WatermarkText = "PREVIEW"
+	}
+	if cfg.ImageURLSecret == "" {
+		cfg.ImageURLSecret = cfg.JWTSecret
+	}
+	if cfg.ImageDownloadTTLSec <= 0 {
+		cfg.ImageDownloadTTLSec = 15 * 60
+	}
+
+	if cfg.HistoryRetentionHours <= 0 {
+		cfg.HistoryRetentionHours = 7 * 24
+	}
+	if cfg.HistorySweepIntervalMins <= 0 {
+		cfg.HistorySweepIntervalMins = 60
+	}
+
+	if cfg.AuthEnabled && cfg.AdminAPIKey == "" && cfg.JWTSecret == "" {
+		panic(errors.New("missing ADMIN_API_KEY or JWT_SECRET"))
+	}
+
 	return cfg
 }
 
@@ -63,3 +210,38 @@ func getEnvInt(key string) int {
 	}
 	return v
 }
+
+func getEnvFloat(key string) float64 {
+	s := getEnvStr(key)
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0
+	}
+	return v
+}
+
+// "key1=value1,key2=value2"
+func getEnvMap(key string) map[string]string {
+	m := make(map[string]string)
+	for _, pair := range strings.Split(getEnvStr(key), ",") {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		m[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return m
+}
+
+// "key1=1.5,key2=3"
+func getEnvFloatMap(key string) map[string]float64 {
+	m := make(map[string]float64)
+	for k, s := range getEnvMap(key) {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			continue
+		}
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/openai"
+	"github.com/namhq1989/demo-ai/pricing"
+	"github.com/namhq1989/demo-ai/stablediffusion"
+	oai "github.com/sashabaranov/go-openai"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var errBudgetExceeded = errors.New("budget exceeded")
+
+type budgetConfig struct {
+	Daily            float64
+	DailyPerUser     float64
+	DailyPerProvider map[string]float64
+
+	// Downgrade drops the providers which do not fit in the budget instead of rejecting the request
+	Downgrade bool
+}
+
+func (b budgetConfig) enabled() bool {
+	return b.Daily > 0 || b.DailyPerUser > 0 || len(b.DailyPerProvider) > 0
+}
+
+// promptCost is the cost of generating the prompt with GPT
+func promptCost() float64 {
+	return pricing.Estimate(providerOpenAI, oai.GPT3Dot5Turbo, "")
+}
+
+func textToImageCost(provider string, in textToImageInput) float64 {
+	switch provider {
+	case providerStableDiffusion:
+		return pricing.Estimate(provider, in.Advanced.stableDiffusion(in.Preset).Model, "")
+	case providerOpenAI:
+		model := oai.CreateImageModelDallE3
+		if in.Advanced.openAI(in.Preset).Quality == oai.CreateImageQualityHD {
+			model += "-hd"
+		}
+		return pricing.Estimate(provider, model, openai.GetSize(in.Product))
+	default:
+		return pricing.Estimate(provider, "", "")
+	}
+}
+
+func editImageCost(provider string) float64 {
+	if provider == providerStableDiffusion {
+		return pricing.Estimate(provider, stablediffusion.ModelInpaint, "")
+	}
+	return pricing.Estimate(provider, "", "")
+}
+
+// checkBudget returns the providers which fit in today's budget, costOf is the cost of the request on a provider.
+// When a cap is hit, the request is rejected with errBudgetExceeded, or the providers which do not fit are dropped
+func (a *app) checkBudget(ctx context.Context, userID, clientIP string, providers []string, costOf func(provider string) float64) ([]string, error) {
+	if !a.budget.enabled() {
+		return providers, nil
+	}
+
+	since := startOfDay(time.Now())
+
+	spentByProvider, err := a.spendByProvider(ctx, bson.M{"createdAt": bson.M{"$gte": since}})
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := math.Inf(1)
+	if a.budget.Daily > 0 {
+		remaining = a.budget.Daily - sum(spentByProvider)
+	}
+
+	if a.budget.DailyPerUser > 0 {
+		match := bson.M{"createdAt": bson.M{"$gte": since}, "userId": userID}
+		if userID == "" {
+			match = bson.M{"createdAt": bson.M{"$gte": since}, "userId": bson.M{"$exists": false}, "clientIp": clientIP}
+		}
+
+		spentByUser, err := a.spendByProvider(ctx, match)
+		if err != nil {
+			return nil, err
+		}
+		remaining = math.Min(remaining, a.budget.DailyPerUser-sum(spentByUser))
+	}
+
+	allowed := make([]string, 0, len(providers))
+	for _, provider := range providers {
+		cost := costOf(provider)
+
+		fits := cost <= remaining
+		if limit, ok := a.budget.DailyPerProvider[provider]; ok && cost > limit-spentByProvider[provider] {
+			fits = false
+		}
+
+		if !fits {
+			if !a.budget.Downgrade {
+				return nil, fmt.Errorf("%w: %s", errBudgetExceeded, provider)
+			}
+			fmt.Printf("[BUDGET] skip %s, cost %.4f does not fit \n", provider, cost)
+			continue
+		}
+
+		remaining -= cost
+		allowed = append(allowed, provider)
+	}
+
+	if len(allowed) == 0 {
+		return nil, errBudgetExceeded
+	}
+	return allowed, nil
+}
+
+// spendByProvider returns the total cost of the matched histories per provider
+func (a *app) spendByProvider(ctx context.Context, match bson.M) (map[string]float64, error) {
+	rows, err := a.aggregateCost(ctx, match, "$service")
+	if err != nil {
+		return nil, err
+	}
+
+	spent := make(map[string]float64, len(rows))
+	for _, row := range rows {
+		spent[row.Key] = row.Cost
+	}
+	return spent, nil
+}
+
+type costRow struct {
+	Key   string  `bson:"_id" json:"key"`
+	Cost  float64 `bson:"cost" json:"cost"`
+	Count int     `bson:"count" json:"count"`
+}
+
+func (a *app) aggregateCost(ctx context.Context, match bson.M, groupBy interface{}) ([]costRow, error) {
+	pipeline := []bson.M{
+		{"$match": match},
+		{"$group": bson.M{"_id": groupBy, "cost": bson.M{"$sum": "$cost"}, "count": bson.M{"$sum": 1}}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	rows := make([]costRow, 0)
+	cursor, err := a.colHistory.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+var mapCostGroupBy = map[string]interface{}{
+	"day":      bson.M{"$dateToString": bson.M{"format": "%Y-%m-%d", "date": "$createdAt"}},
+	"provider": "$service",
+	"user":     bson.M{"$ifNull": bson.A{"$userId", "$clientIp"}},
+}
+
+// costs reports the spend grouped by day, provider or user, between "from" and "to" (YYYY-MM-DD),
+// anonymous requests are grouped by ip
+func (a *app) costs(c echo.Context) error {
+	groupBy, ok := mapCostGroupBy[c.QueryParam("groupBy")]
+	if !ok {
+		groupBy = mapCostGroupBy["day"]
+	}
+
+	createdAt := bson.M{}
+	if from := c.QueryParam("from"); from != "" {
+		t, err := time.Parse(time.DateOnly, from)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid from date"})
+		}
+		createdAt["$gte"] = t
+	}
+	if to := c.QueryParam("to"); to != "" {
+		t, err := time.Parse(time.DateOnly, to)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid to date"})
+		}
+		createdAt["$lt"] = t.AddDate(0, 0, 1)
+	}
+
+	match := bson.M{}
+	if len(createdAt) > 0 {
+		match["createdAt"] = createdAt
+	}
+
+	rows, err := a.aggregateCost(c.Request().Context(), match, groupBy)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	var total float64
+	for _, row := range rows {
+		total += row.Cost
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"costs": rows, "total": total})
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.UTC().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func sum(m map[string]float64) float64 {
+	var total float64
+	for _, v := range m {
+		total += v
+	}
+	return total
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	errInsufficientCredits = errors.New("insufficient credits")
+	errFreeTierQuota       = errors.New("free tier quota reached")
+)
+
+type creditConfig struct {
+	// FreeCredits is granted to an account on its first generation
+	FreeCredits int64
+
+	// FreeDallEDaily caps the DALL-E images of a free tier account per day, 0 means no cap
+	FreeDallEDaily int64
+}
+
+// credits charged per image for every operation and provider
+var mapCreditCost = map[string]map[string]int64{
+	"text-to-image": {
+		providerStableDiffusion: 4,
+		providerOpenAI:          8,
+		providerProdia:          1,
+	},
+	"edit-image": {
+		providerStableDiffusion: 3,
+		providerProdia:          1,
+	},
+}
+
+func creditCost(operation, provider string) int64 {
+	return mapCreditCost[operation][provider]
+}
+
+// usesCredits reports whether the caller pays for generations, anonymous callers and admins do not
+func (a *app) usesCredits(user caller) bool {
+	return a.auth.Enabled && user.ID != "" && !user.isAdmin()
+}
+
+// creditAccount returns the account of the user, it is created with the free credits on first use
+func (a *app) creditAccount(ctx context.Context, userID string) (database.CreditAccount, error) {
+	now := time.Now()
+
+	var account database.CreditAccount
+	err := a.colCreditAccount.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$setOnInsert": bson.M{
+			"balance":   a.credit.FreeCredits,
+			"tier":      database.TierFree,
+			"createdAt": now,
+			"updatedAt": now,
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&account)
+
+	return account, err
+}
+
+// debitCredits atomically takes amount from the balance, it fails with errInsufficientCredits when the balance is too low
+func (a *app) debitCredits(ctx context.Context, userID, operation string, providers []string, generationID primitive.ObjectID, amount int64) error {
+	if _, err := a.creditAccount(ctx, userID); err != nil {
+		return err
+	}
+
+	res, err := a.colCreditAccount.UpdateOne(ctx,
+		bson.M{"_id": userID, "balance": bson.M{"$gte": amount}},
+		bson.M{"$inc": bson.M{"balance": -amount}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.ModifiedCount == 0 {
+		return errInsufficientCredits
+	}
+
+	a.addCreditEntry(ctx, database.CreditEntry{
+		UserID:       userID,
+		Amount:       -amount,
+		Reason:       database.CreditReasonGeneration,
+		Operation:    operation,
+		Providers:    providers,
+		GenerationID: generationID,
+	})
+	return nil
+}
+
+// refundCredits gives back the credits of the images which failed
+func (a *app) refundCredits(ctx context.Context, userID, operation string, providers []string, generationID primitive.ObjectID, amount int64) {
+	if amount <= 0 {
+		return
+	}
+
+	_, err := a.colCreditAccount.UpdateOne(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"balance": amount}, "$set": bson.M{"updatedAt": time.Now()}},
+	)
+	if err != nil {
+		fmt.Println("[CREDIT] error when refunding credits:", err.Error())
+		return
+	}
+
+	a.addCreditEntry(ctx, database.CreditEntry{
+		UserID:       userID,
+		Amount:       amount,
+		Reason:       database.CreditReasonRefund,
+		Operation:    operation,
+		Providers:    providers,
+		GenerationID: generationID,
+	})
+}
+
+func (a *app) addCreditEntry(ctx context.Context, entry database.CreditEntry) {
+	entry.ID = database.NewObjectID()
+	entry.CreatedAt = time.Now()
+	if _, err := a.colCreditEntry.InsertOne(ctx, entry); err != nil {
+		fmt.Println("[CREDIT] error when persisting ledger entry:", err.Error())
+	}
+}
+
+// chargeImages debits the credits of the images about to be generated,
+// the returned refund function must be called with the results to give back the credits of the failed images
+func (a *app) chargeImages(ctx context.Context, user caller, operation string, providers []string, generationID primitive.ObjectID) (func(images []imageResult), error) {
+	noop := func([]imageResult) {}
+	if !a.usesCredits(user) {
+		return noop, nil
+	}
+
+	var amount int64
+	for _, provider := range providers {
+		amount += creditCost(operation, provider)
+	}
+
+	if err := a.debitCredits(ctx, user.ID, operation, providers, generationID, amount); err != nil {
+		return noop, err
+	}
+
+	return func(images []imageResult) {
+		var (
+			refund int64
+			failed = make([]string, 0)
+		)
+		for i, image := range images {
+			// a pending image is still generated, the credits are refunded if it fails once resumed
+			if image.URL == "" && !image.Pending {
+				// an image which failed over was re-charged at the price of the alternate provider
+				provider := providers[i]
+				if image.Provider != "" {
+					provider = image.Provider
+				}
+				refund += creditCost(operation, provider)
+				failed = append(failed, provider)
+			}
+		}
+		a.refundCredits(context.Background(), user.ID, operation, failed, generationID, refund)
+	}, nil
+}
+
+// rechargeImage debits or refunds the difference of credits when an image charged on a provider is generated by another
+func (a *app) rechargeImage(ctx context.Context, userID, operation, from, to string, generationID primitive.ObjectID) error {
+	diff := creditCost(operation, to) - creditCost(operation, from)
+	if diff > 0 {
+		return a.debitCredits(ctx, userID, operation, []string{to}, generationID, diff)
+	}
+	a.refundCredits(ctx, userID, operation, []string{from}, generationID, -diff)
+	return nil
+}
+
+// applyFreeTierQuota drops DALL-E from the providers once a free tier account reached its daily cap
+func (a *app) applyFreeTierQuota(ctx context.Context, user caller, providers []string, n int) ([]string, error) {
+	if !a.usesCredits(user) || !containsProvider(providers, providerOpenAI) {
+		return providers, nil
+	}
+
+	fits, err := a.freeTierAllowsDallE(ctx, user.ID, n)
+	if err != nil {
+		return nil, err
+	}
+	if fits {
+		return providers, nil
+	}
+
+	allowed := make([]string, 0, len(providers))
+	for _, p := range providers {
+		if p != providerOpenAI {
+			allowed = append(allowed, p)
+		}
+	}
+	if len(allowed) == 0 {
+		return nil, errFreeTierQuota
+	}
+	return allowed, nil
+}
+
+// freeTierAllowsDallE reports whether n more DALL-E images fit in today's cap of the account, only the free tier is capped
+func (a *app) freeTierAllowsDallE(ctx context.Context, userID string, n int) (bool, error) {
+	if a.credit.FreeDallEDaily <= 0 {
+		return true, nil
+	}
+
+	account, err := a.creditAccount(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	if account.Tier != database.TierFree {
+		return true, nil
+	}
+	return a.freeDallEFits(ctx, userID, n)
+}
+
+// freeDallEFits reports whether n more DALL-E images fit in today's cap of a free tier account,
+// the deleted histories are counted so deleting an image does not give the quota back
+func (a *app) freeDallEFits(ctx context.Context, userID string, n int) (bool, error) {
+	used, err := a.historyRepo.Count(ctx, database.HistoryFilter{
+		UserID:         userID,
+		Service:        providerOpenAI,
+		From:           startOfDay(time.Now()),
+		IncludeDeleted: true,
+	})
+	if err != nil {
+		return false, err
+	}
+	return used+int64(n) <= a.credit.FreeDallEDaily, nil
+}
+
+func (a *app) credits(c echo.Context) error {
+	user := getCaller(c)
+	if user.ID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"message": "missing credentials"})
+	}
+
+	account, err := a.creditAccount(c.Request().Context(), user.ID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"account": account, "costs": mapCreditCost})
+}
+
+func (a *app) creditEntries(c echo.Context) error {
+	user := getCaller(c)
+	if user.ID == "" {
+		return c.JSON(http.StatusUnauthorized, echo.Map{"message": "missing credentials"})
+	}
+
+	ctx := c.Request().Context()
+
+	var limit int64 = 50
+	entries := make([]database.CreditEntry, 0)
+	cursor, err := a.colCreditEntry.Find(ctx, bson.M{"userId": user.ID}, &options.FindOptions{Sort: bson.M{"_id": -1}, Limit: &limit})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if err = cursor.All(ctx, &entries); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"entries": entries})
+}
+
+type topUpCreditsPayload struct {
+	Amount int64  `json:"amount"`
+	Tier   string `json:"tier"`
+}
+
+// topUpCredits adds credits to the account of a user and optionally changes its tier
+func (a *app) topUpCredits(c echo.Context) error {
+	var payload topUpCreditsPayload
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if payload.Amount <= 0 {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "amount must be positive"})
+	}
+	if payload.Tier != "" && payload.Tier != database.TierFree && payload.Tier != database.TierPro {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid tier"})
+	}
+
+	var (
+		ctx    = c.Request().Context()
+		userID = c.Param("id")
+	)
+
+	exists, err := a.userExists(ctx, userID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if !exists {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "user not found"})
+	}
+
+	if _, err = a.creditAccount(ctx, userID); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	set := bson.M{"updatedAt": time.Now()}
+	if payload.Tier != "" {
+		set["tier"] = payload.Tier
+	}
+
+	var account database.CreditAccount
+	err = a.colCreditAccount.FindOneAndUpdate(ctx,
+		bson.M{"_id": userID},
+		bson.M{"$inc": bson.M{"balance": payload.Amount}, "$set": set},
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(&account)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": "account not found"})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	a.addCreditEntry(ctx, database.CreditEntry{
+		UserID: userID,
+		Amount: payload.Amount,
+		Reason: database.CreditReasonTopUp,
+	})
+
+	return c.JSON(http.StatusOK, echo.Map{"account": account})
+}
+
+// userExists reports whether the id is a registered user or a caller which already has a credit account,
+// users authenticated with a jwt are not registered
+func (a *app) userExists(ctx context.Context, userID string) (bool, error) {
+	if id, err := database.ObjectIDFromString(userID); err == nil {
+		err = a.colUser.FindOne(ctx, bson.M{"_id": id}).Err()
+		if err == nil {
+			return true, nil
+		}
+		if !errors.Is(err, mongo.ErrNoDocuments) {
+			return false, err
+		}
+	}
+
+	err := a.colCreditAccount.FindOne(ctx, bson.M{"_id": userID}).Err()
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/namhq1989/demo-ai/database"
+)
+
+func TestFreeDallEQuotaIsNotGivenBackByDeleting(t *testing.T) {
+	a := newTestApp()
+	a.credit.FreeDallEDaily = 2
+
+	user := caller{ID: "alice"}
+	first := createHistory(t, a, database.History{UserID: user.ID, Service: providerOpenAI})
+	createHistory(t, a, database.History{UserID: user.ID, Service: providerStableDiffusion})
+
+	fits, err := a.freeDallEFits(context.Background(), user.ID, 1)
+	if err != nil || !fits {
+		t.Fatalf("second image does not fit: %v", err)
+	}
+	createHistory(t, a, database.History{UserID: user.ID, Service: providerOpenAI})
+
+	c, rec := newTestContext(http.MethodDelete, "/histories/"+first.ID.Hex(), user)
+	c.SetParamNames("id")
+	c.SetParamValues(first.ID.Hex())
+	if err = a.deleteHistory(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	if fits, err = a.freeDallEFits(context.Background(), user.ID, 1); err != nil || fits {
+		t.Fatalf("third image fits after deleting the first one: %v", err)
+	}
+}
@@ -0,0 +1,53 @@
+package database
+
+import (
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// HistorySchemaVersion is the shape of the histories written by this version,
+// older records are rewritten by the migration command, see cmd/migrate
+const HistorySchemaVersion = 2
+
+// AIConfiguration is the resolved configuration of the provider which rendered the image, only one field is set
+type AIConfiguration struct {
+	Prodia          *ProdiaConfiguration    `bson:"prodia,omitempty" json:"prodia,omitempty"`
+	OpenAI          *OpenAIConfiguration    `bson:"openai,omitempty" json:"openai,omitempty"`
+	StableDiffusion *StabilityConfiguration `bson:"stableDiffusion,omitempty" json:"stableDiffusion,omitempty"`
+}
+
+type ProdiaConfiguration struct {
+	Model          string `bson:"model" json:"model"`
+	Sampler        string `bson:"sampler,omitempty" json:"sampler,omitempty"`
+	Steps          int    `bson:"steps,omitempty" json:"steps,omitempty"`
+	CFGScale       int    `bson:"cfgScale,omitempty" json:"cfgScale,omitempty"`
+	NegativePrompt string `bson:"negativePrompt,omitempty" json:"negativePrompt,omitempty"`
+	Seed           int    `bson:"seed,omitempty" json:"seed,omitempty"`
+	Width          int    `bson:"width,omitempty" json:"width,omitempty"`
+	Height         int    `bson:"height,omitempty" json:"height,omitempty"`
+}
+
+type OpenAIConfiguration struct {
+	Model   string `bson:"model" json:"model"`
+	Size    string `bson:"size,omitempty" json:"size,omitempty"`
+	Quality string `bson:"quality,omitempty" json:"quality,omitempty"`
+	Style   string `bson:"style,omitempty" json:"style,omitempty"`
+}
+
+type StabilityConfiguration struct {
+	Model          string `bson:"model" json:"model"`
+	AspectRatio    string `bson:"aspectRatio,omitempty" json:"aspectRatio,omitempty"`
+	NegativePrompt string `bson:"negativePrompt,omitempty" json:"negativePrompt,omitempty"`
+	Seed           int    `bson:"seed,omitempty" json:"seed,omitempty"`
+}
+
+// UnmarshalBSONValue keeps the records written before the migration readable, their JSON string configuration is skipped
+func (c *AIConfiguration) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	if t != bson.TypeEmbeddedDocument {
+		*c = AIConfiguration{}
+		return nil
+	}
+
+	type plain AIConfiguration
+	return bson.Unmarshal(data, (*plain)(c))
+}
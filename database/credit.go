@@ -0,0 +1,39 @@
+package database
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	TierFree = "free"
+	TierPro  = "pro"
+)
+
+const (
+	CreditReasonGeneration = "generation"
+	CreditReasonRefund     = "refund"
+	CreditReasonTopUp      = "top-up"
+)
+
+// CreditAccount is the credit balance of a user, ID is the user id
+type CreditAccount struct {
+	ID        string    `bson:"_id" json:"userId"`
+	Balance   int64     `bson:"balance" json:"balance"`
+	Tier      string    `bson:"tier" json:"tier"`
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// CreditEntry is a line of the ledger, Amount is negative for debits
+type CreditEntry struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	UserID       string             `bson:"userId" json:"userId"`
+	Amount       int64              `bson:"amount" json:"amount"`
+	Reason       string             `bson:"reason" json:"reason"`
+	Operation    string             `bson:"operation,omitempty" json:"operation,omitempty"`
+	Providers    []string           `bson:"providers,omitempty" json:"providers,omitempty"`
+	GenerationID primitive.ObjectID `bson:"generationId,omitempty" json:"generationId,omitempty"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+}
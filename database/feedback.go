@@ -0,0 +1,52 @@
+package database
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ReactionLike    = "like"
+	ReactionDislike = "dislike"
+)
+
+// Feedback is the opinion of a voter on a history, the provider fields are copied from the history for the stats.
+// VoterID is the user id, or the ip prefixed with "ip:" for anonymous callers
+type Feedback struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	HistoryID    primitive.ObjectID `bson:"historyId" json:"historyId"`
+	GenerationID primitive.ObjectID `bson:"generationId" json:"generationId"`
+	VoterID      string             `bson:"voterId" json:"voterId"`
+	Reaction     string             `bson:"reaction,omitempty" json:"reaction,omitempty"`
+	Rating       int                `bson:"rating,omitempty" json:"rating,omitempty"`
+	Comment      string             `bson:"comment,omitempty" json:"comment,omitempty"`
+	Service      string             `bson:"service" json:"service"`
+	AIModel      string             `bson:"aiModel" json:"aiModel"`
+	Style        string             `bson:"style" json:"style"`
+	Product      string             `bson:"product" json:"product"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt    time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// GenerationWinner is the image a voter picked among the images of a generation
+type GenerationWinner struct {
+	ID           primitive.ObjectID `bson:"_id" json:"id"`
+	GenerationID primitive.ObjectID `bson:"generationId" json:"generationId"`
+	VoterID      string             `bson:"voterId" json:"voterId"`
+	HistoryID    primitive.ObjectID `bson:"historyId" json:"historyId"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// FeedbackIndexes keep a single feedback per voter and history
+var FeedbackIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "historyId", Value: 1}, {Key: "voterId", Value: 1}}, Options: options.Index().SetUnique(true)},
+}
+
+// GenerationWinnerIndexes keep a single winner per voter and generation
+var GenerationWinnerIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "generationId", Value: 1}, {Key: "voterId", Value: 1}}, Options: options.Index().SetUnique(true)},
+}
@@ -3,25 +3,171 @@ package database
 import (
 	"time"
 
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type History struct {
-	ID                 primitive.ObjectID `bson:"_id" json:"id"`
-	Name               string             `bson:"name" json:"name"`
-	Service            string             `bson:"service" json:"service"`
-	Type               string             `bson:"type" json:"type"`
-	AIModel            string             `bson:"aiModel" json:"aiModel"`
-	AIConfiguration    string             `bson:"aiConfiguration" json:"aiConfiguration"`
-	Prompt             string             `bson:"prompt" json:"prompt"`
-	Description        string             `bson:"description" json:"description"`
-	Style              string             `bson:"style" json:"style"`
-	ColorScheme        string             `bson:"colorScheme" json:"colorScheme"`
-	Text               string             `bson:"text" json:"text"`
-	TextStyle          string             `bson:"textStyle" json:"textStyle"`
-	Layout             string             `bson:"layout" json:"layout"`
-	Theme              string             `bson:"theme" json:"theme"`
-	AdditionalElements string             `bson:"additionalElements" json:"additionalElements"`
-	Product            string             `bson:"product" json:"product"`
-	CreatedAt          time.Time          `bson:"createdAt" json:"createdAt"`
+	ID                 primitive.ObjectID  `bson:"_id" json:"id"`
+	GenerationID       primitive.ObjectID  `bson:"generationId" json:"generationId"`
+	ParentID           *primitive.ObjectID `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	RootID             *primitive.ObjectID `bson:"rootId,omitempty" json:"rootId,omitempty"`
+	UserID             string              `bson:"userId,omitempty" json:"userId,omitempty"`
+	Name               string              `bson:"name" json:"name"`
+	Thumbnail          string              `bson:"thumbnail,omitempty" json:"thumbnail,omitempty"`
+	Service            string              `bson:"service" json:"service"`
+	Type               string              `bson:"type" json:"type"`
+	AIModel            string              `bson:"aiModel" json:"aiModel"`
+	AIConfiguration    AIConfiguration     `bson:"aiConfiguration" json:"aiConfiguration"`
+	Prompt             string              `bson:"prompt" json:"prompt"`
+	Description        string              `bson:"description" json:"description"`
+	Style              string              `bson:"style" json:"style"`
+	ColorScheme        string              `bson:"colorScheme" json:"colorScheme"`
+	Text               string              `bson:"text" json:"text"`
+	TextStyle          string              `bson:"textStyle" json:"textStyle"`
+	Layout             string              `bson:"layout" json:"layout"`
+	Theme              string              `bson:"theme" json:"theme"`
+	AdditionalElements string              `bson:"additionalElements" json:"additionalElements"`
+	Product            string              `bson:"product" json:"product"`
+	InputImage         *ImageRef           `bson:"inputImage,omitempty" json:"inputImage,omitempty"`
+	Cost               float64             `bson:"cost" json:"cost"`
+	ClientIP           string              `bson:"clientIp" json:"clientIp"`
+	CreatedAt          time.Time           `bson:"createdAt" json:"createdAt"`
+	DeletedAt          *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	FilesSweptAt       *time.Time          `bson:"filesSweptAt,omitempty" json:"filesSweptAt,omitempty"`
+	ApprovedAt         *time.Time          `bson:"approvedAt,omitempty" json:"approvedAt,omitempty"`
+	Exports            []PrintExport       `bson:"exports,omitempty" json:"exports,omitempty"`
+	TextOverlay        *TextOverlay        `bson:"textOverlay,omitempty" json:"textOverlay,omitempty"`
+	SchemaVersion      int                 `bson:"schemaVersion" json:"schemaVersion"`
+}
+
+// TextOverlay records how the text was drawn on the image, Base is the url of the image without the text
+type TextOverlay struct {
+	Base       string `bson:"base" json:"base"`
+	Font       string `bson:"font" json:"font"`
+	Color      string `bson:"color" json:"color"`
+	Effect     string `bson:"effect" json:"effect"`
+	Vertical   string `bson:"vertical" json:"vertical"`
+	Horizontal string `bson:"horizontal" json:"horizontal"`
+}
+
+// PrintExport is a print-ready file of the history, a history keeps the last export of every product
+type PrintExport struct {
+	Product     string  `bson:"product" json:"product"`
+	Name        string  `bson:"name" json:"name"`
+	Hash        string  `bson:"hash" json:"hash"`
+	Size        int64   `bson:"size" json:"size"`
+	ColorIntent string  `bson:"colorIntent" json:"colorIntent"`
+	DPI         int     `bson:"dpi" json:"dpi"`
+	BleedIn     float64 `bson:"bleedIn" json:"bleedIn"`
+
+	// validation report
+	WidthPx      int      `bson:"widthPx" json:"widthPx"`
+	HeightPx     int      `bson:"heightPx" json:"heightPx"`
+	EffectiveDPI float64  `bson:"effectiveDpi" json:"effectiveDpi"`
+	Warnings     []string `bson:"warnings" json:"warnings"`
+
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
+
+// HistoryFilter narrows down the histories, zero values are ignored
+type HistoryFilter struct {
+	UserID  string
+	Service string
+	Type    string
+	Product string
+	Style   string
+	AIModel string
+	From    time.Time
+	To      time.Time
+
+	// Search is a full-text search over the prompt and the description
+	Search string
+
+	// RootID lists the histories derived from the root, by edits or variations
+	RootID primitive.ObjectID
+
+	// Cursor is the id of the last history of the previous page
+	Cursor primitive.ObjectID
+
+	// Deleted lists the soft deleted histories instead of the live ones
+	Deleted bool
+
+	// IncludeDeleted lists both the live and the soft deleted histories, for the quotas which deleting must not give back
+	IncludeDeleted bool
+
+	// DeletedBefore lists the histories deleted before the time whose files are not swept yet, it implies Deleted
+	DeletedBefore time.Time
+}
+
+func (f HistoryFilter) BSON() bson.M {
+	m := bson.M{}
+
+	for key, value := range map[string]string{
+		"userId":  f.UserID,
+		"service": f.Service,
+		"type":    f.Type,
+		"product": f.Product,
+		"style":   f.Style,
+		"aiModel": f.AIModel,
+	} {
+		if value != "" {
+			m[key] = value
+		}
+	}
+
+	createdAt := bson.M{}
+	if !f.From.IsZero() {
+		createdAt["$gte"] = f.From
+	}
+	if !f.To.IsZero() {
+		createdAt["$lt"] = f.To
+	}
+	if len(createdAt) > 0 {
+		m["createdAt"] = createdAt
+	}
+
+	if !f.RootID.IsZero() {
+		m["rootId"] = f.RootID
+	}
+
+	if f.Search != "" {
+		m["$text"] = bson.M{"$search": f.Search}
+	}
+
+	if !f.Cursor.IsZero() {
+		m["_id"] = bson.M{"$lt": f.Cursor}
+	}
+
+	if !f.IncludeDeleted {
+		m["deletedAt"] = bson.M{"$exists": f.Deleted}
+	}
+	if !f.DeletedBefore.IsZero() {
+		m["deletedAt"] = bson.M{"$lt": f.DeletedBefore}
+		m["filesSweptAt"] = bson.M{"$exists": false}
+	}
+
+	return m
+}
+
+// HistoryIndexes backs the filters of HistoryFilter, the text index powers the search
+var HistoryIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "prompt", Value: "text"}, {Key: "description", Value: "text"}}, Options: options.Index().SetName("search")},
+	{Keys: bson.D{{Key: "userId", Value: 1}, {Key: "_id", Value: -1}}},
+	{Keys: bson.D{{Key: "service", Value: 1}, {Key: "_id", Value: -1}}},
+	{Keys: bson.D{{Key: "generationId", Value: 1}}},
+	{Keys: bson.D{{Key: "rootId", Value: 1}}, Options: options.Index().SetSparse(true)},
+	{Keys: bson.D{{Key: "createdAt", Value: -1}}},
+	{Keys: bson.D{{Key: "deletedAt", Value: 1}}, Options: options.Index().SetSparse(true)},
+}
+
+// Lineage returns the parent and root ids of a history derived from h
+func (h History) Lineage() (parentID, rootID *primitive.ObjectID) {
+	parent, root := h.ID, h.ID
+	if h.RootID != nil {
+		root = *h.RootID
+	}
+	return &parent, &root
 }
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryHistoryRepository keeps the histories in process, it is meant for tests and local runs
+type memoryHistoryRepository struct {
+	mu        sync.RWMutex
+	histories map[primitive.ObjectID]History
+}
+
+func NewMemoryHistoryRepository() HistoryRepository {
+	return &memoryHistoryRepository{histories: make(map[primitive.ObjectID]History)}
+}
+
+func (r *memoryHistoryRepository) Create(_ context.Context, history History) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history.SchemaVersion = HistorySchemaVersion
+	r.histories[history.ID] = history
+	return nil
+}
+
+func (r *memoryHistoryRepository) Get(_ context.Context, id primitive.ObjectID) (History, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	history, ok := r.histories[id]
+	if !ok {
+		return History{}, ErrHistoryNotFound
+	}
+	return history, nil
+}
+
+func (r *memoryHistoryRepository) List(_ context.Context, filter HistoryFilter, limit int64) ([]History, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	histories := make([]History, 0)
+	for _, history := range r.histories {
+		if filter.match(history) {
+			histories = append(histories, history)
+		}
+	}
+
+	sort.Slice(histories, func(i, j int) bool {
+		return histories[i].ID.Hex() > histories[j].ID.Hex()
+	})
+
+	if limit > 0 && int64(len(histories)) > limit {
+		histories = histories[:limit]
+	}
+	return histories, nil
+}
+
+func (r *memoryHistoryRepository) Count(ctx context.Context, filter HistoryFilter) (int64, error) {
+	histories, err := r.List(ctx, filter, 0)
+	return int64(len(histories)), err
+}
+
+func (r *memoryHistoryRepository) UpdateStatus(_ context.Context, id primitive.ObjectID, status HistoryStatus) (History, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history, ok := r.histories[id]
+	if !ok || (history.DeletedAt != nil) == (status == HistoryStatusDeleted) || history.FilesSweptAt != nil {
+		return History{}, ErrHistoryNotFound
+	}
+
+	history.DeletedAt = nil
+	if status == HistoryStatusDeleted {
+		now := time.Now()
+		history.DeletedAt = &now
+	}
+
+	r.histories[id] = history
+	return history, nil
+}
+
+func (r *memoryHistoryRepository) Approve(_ context.Context, id primitive.ObjectID) (History, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history, ok := r.histories[id]
+	if !ok || history.DeletedAt != nil {
+		return History{}, ErrHistoryNotFound
+	}
+
+	if history.ApprovedAt == nil {
+		now := time.Now()
+		history.ApprovedAt = &now
+		r.histories[id] = history
+	}
+	return history, nil
+}
+
+func (r *memoryHistoryRepository) SaveExport(_ context.Context, id primitive.ObjectID, export PrintExport) (History, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history, ok := r.histories[id]
+	if !ok || history.DeletedAt != nil {
+		return History{}, ErrHistoryNotFound
+	}
+
+	exports := make([]PrintExport, 0, len(history.Exports)+1)
+	for _, e := range history.Exports {
+		if e.Product != export.Product {
+			exports = append(exports, e)
+		}
+	}
+	history.Exports = append(exports, export)
+
+	r.histories[id] = history
+	return history, nil
+}
+
+func (r *memoryHistoryRepository) MarkFilesSwept(_ context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	history, ok := r.histories[id]
+	if !ok || history.DeletedAt == nil {
+		return ErrHistoryNotFound
+	}
+
+	now := time.Now()
+	history.FilesSweptAt = &now
+	r.histories[id] = history
+	return nil
+}
+
+// match mirrors BSON for the in-memory repository, the search matches any word of the prompt or the description
+func (f HistoryFilter) match(h History) bool {
+	for _, pair := range [][2]string{
+		{f.UserID, h.UserID},
+		{f.Service, h.Service},
+		{f.Type, h.Type},
+		{f.Product, h.Product},
+		{f.Style, h.Style},
+		{f.AIModel, h.AIModel},
+	} {
+		if pair[0] != "" && pair[0] != pair[1] {
+			return false
+		}
+	}
+
+	if !f.From.IsZero() && h.CreatedAt.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !h.CreatedAt.Before(f.To) {
+		return false
+	}
+
+	if !f.RootID.IsZero() && (h.RootID == nil || *h.RootID != f.RootID) {
+		return false
+	}
+
+	if f.Search != "" {
+		text := strings.ToLower(h.Prompt + " " + h.Description)
+		found := false
+		for _, word := range strings.Fields(strings.ToLower(f.Search)) {
+			if strings.Contains(text, word) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if !f.Cursor.IsZero() && h.ID.Hex() >= f.Cursor.Hex() {
+		return false
+	}
+
+	if !f.DeletedBefore.IsZero() {
+		return h.DeletedAt != nil && h.DeletedAt.Before(f.DeletedBefore) && h.FilesSweptAt == nil
+	}
+	return f.IncludeDeleted || (h.DeletedAt != nil) == f.Deleted
+}
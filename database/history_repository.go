@@ -0,0 +1,154 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrHistoryNotFound = errors.New("history not found")
+
+// HistoryStatus is the lifecycle status of a history, a deleted history is kept until it is swept
+type HistoryStatus string
+
+const (
+	HistoryStatusActive  HistoryStatus = "active"
+	HistoryStatusDeleted HistoryStatus = "deleted"
+)
+
+// HistoryRepository stores the histories, Get, UpdateStatus and MarkFilesSwept return ErrHistoryNotFound for unknown ids.
+// Create stamps the history with HistorySchemaVersion
+type HistoryRepository interface {
+	Create(ctx context.Context, history History) error
+	Get(ctx context.Context, id primitive.ObjectID) (History, error)
+
+	// List returns the matched histories, newest first, limit 0 means no limit
+	List(ctx context.Context, filter HistoryFilter, limit int64) ([]History, error)
+	Count(ctx context.Context, filter HistoryFilter) (int64, error)
+
+	// UpdateStatus soft deletes or restores the history, it returns ErrHistoryNotFound when the history is already in the status.
+	// A history whose files were swept cannot be restored
+	UpdateStatus(ctx context.Context, id primitive.ObjectID, status HistoryStatus) (History, error)
+
+	// Approve releases the clean image of a live history, approving twice keeps the first approval time
+	Approve(ctx context.Context, id primitive.ObjectID) (History, error)
+
+	// SaveExport stores the export of a live history, it replaces the previous export of the product
+	SaveExport(ctx context.Context, id primitive.ObjectID, export PrintExport) (History, error)
+
+	// MarkFilesSwept records that the files of a deleted history were removed, the history itself is kept for the reports
+	MarkFilesSwept(ctx context.Context, id primitive.ObjectID) error
+}
+
+type mongoHistoryRepository struct {
+	col *mongo.Collection
+}
+
+func NewMongoHistoryRepository(db *mongo.Database) HistoryRepository {
+	return mongoHistoryRepository{col: ColHistory(db)}
+}
+
+func (r mongoHistoryRepository) Create(ctx context.Context, history History) error {
+	history.SchemaVersion = HistorySchemaVersion
+	_, err := r.col.InsertOne(ctx, history)
+	return err
+}
+
+func (r mongoHistoryRepository) Get(ctx context.Context, id primitive.ObjectID) (History, error) {
+	var history History
+	err := r.col.FindOne(ctx, bson.M{"_id": id}).Decode(&history)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return history, ErrHistoryNotFound
+	}
+	return history, err
+}
+
+func (r mongoHistoryRepository) List(ctx context.Context, filter HistoryFilter, limit int64) ([]History, error) {
+	opts := options.Find().SetSort(bson.M{"_id": -1})
+	if limit > 0 {
+		opts.SetLimit(limit)
+	}
+
+	histories := make([]History, 0)
+	cursor, err := r.col.Find(ctx, filter.BSON(), opts)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &histories); err != nil {
+		return nil, err
+	}
+	return histories, nil
+}
+
+func (r mongoHistoryRepository) Count(ctx context.Context, filter HistoryFilter) (int64, error) {
+	return r.col.CountDocuments(ctx, filter.BSON())
+}
+
+func (r mongoHistoryRepository) UpdateStatus(ctx context.Context, id primitive.ObjectID, status HistoryStatus) (History, error) {
+	var (
+		filter = bson.M{"_id": id, "deletedAt": bson.M{"$exists": true}, "filesSweptAt": bson.M{"$exists": false}}
+		update = bson.M{"$unset": bson.M{"deletedAt": ""}}
+	)
+	if status == HistoryStatusDeleted {
+		filter["deletedAt"] = bson.M{"$exists": false}
+		update = bson.M{"$set": bson.M{"deletedAt": time.Now()}}
+	}
+
+	var history History
+	err := r.col.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&history)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return history, ErrHistoryNotFound
+	}
+	return history, err
+}
+
+func (r mongoHistoryRepository) Approve(ctx context.Context, id primitive.ObjectID) (History, error) {
+	var (
+		filter = bson.M{"_id": id, "deletedAt": bson.M{"$exists": false}}
+		update = mongo.Pipeline{{{Key: "$set", Value: bson.M{"approvedAt": bson.M{"$ifNull": bson.A{"$approvedAt", time.Now()}}}}}}
+	)
+
+	var history History
+	err := r.col.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&history)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return history, ErrHistoryNotFound
+	}
+	return history, err
+}
+
+func (r mongoHistoryRepository) SaveExport(ctx context.Context, id primitive.ObjectID, export PrintExport) (History, error) {
+	var (
+		filter = bson.M{"_id": id, "deletedAt": bson.M{"$exists": false}}
+		others = bson.M{"$filter": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$exports", bson.A{}}},
+			"cond":  bson.M{"$ne": bson.A{"$$this.product", export.Product}},
+		}}
+		update = mongo.Pipeline{{{Key: "$set", Value: bson.M{"exports": bson.M{"$concatArrays": bson.A{others, bson.A{bson.M{"$literal": export}}}}}}}}
+	)
+
+	var history History
+	err := r.col.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&history)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return history, ErrHistoryNotFound
+	}
+	return history, err
+}
+
+func (r mongoHistoryRepository) MarkFilesSwept(ctx context.Context, id primitive.ObjectID) error {
+	res, err := r.col.UpdateOne(ctx,
+		bson.M{"_id": id, "deletedAt": bson.M{"$exists": true}},
+		bson.M{"$set": bson.M{"filesSweptAt": time.Now()}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrHistoryNotFound
+	}
+	return nil
+}
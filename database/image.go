@@ -0,0 +1,37 @@
+package database
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Image is an input image, the same content is stored once whatever the number of edits
+type Image struct {
+	ID          primitive.ObjectID `bson:"_id" json:"id"`
+	Hash        string             `bson:"hash" json:"hash"`
+	Name        string             `bson:"name" json:"name"`
+	URL         string             `bson:"url" json:"url"`
+	Size        int64              `bson:"size" json:"size"`
+	ContentType string             `bson:"contentType" json:"contentType"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+// ImageRef points a history to its input image
+type ImageRef struct {
+	ID   primitive.ObjectID `bson:"id" json:"id"`
+	URL  string             `bson:"url" json:"url"`
+	Hash string             `bson:"hash" json:"hash"`
+}
+
+func (i Image) Ref() ImageRef {
+	return ImageRef{ID: i.ID, URL: i.URL, Hash: i.Hash}
+}
+
+// ImageIndexes dedupe the images by content hash
+var ImageIndexes = []mongo.IndexModel{
+	{Keys: bson.D{{Key: "hash", Value: 1}}, Options: options.Index().SetUnique(true)},
+}
@@ -0,0 +1,134 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// MigrateHistories rewrites the histories, and the histories of the pending Prodia jobs,
+// written before HistorySchemaVersion into the current shape. It returns the number of rewritten records
+func MigrateHistories(ctx context.Context, db *mongo.Database) (int, error) {
+	histories, err := migrateCollection(ctx, ColHistory(db), "")
+	if err != nil {
+		return histories, fmt.Errorf("failed to migrate histories: %v", err)
+	}
+
+	jobs, err := migrateCollection(ctx, ColProdiaJob(db), "history.")
+	if err != nil {
+		return histories + jobs, fmt.Errorf("failed to migrate prodia jobs: %v", err)
+	}
+
+	return histories + jobs, nil
+}
+
+// migrateCollection migrates the history found at prefix in every document of the collection
+func migrateCollection(ctx context.Context, col *mongo.Collection, prefix string) (int, error) {
+	cursor, err := col.Find(ctx, bson.M{prefix + "schemaVersion": bson.M{"$not": bson.M{"$gte": HistorySchemaVersion}}})
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = cursor.Close(ctx) }()
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		doc := cursor.Current
+
+		history := doc
+		if prefix != "" {
+			nested, ok := doc.Lookup("history").DocumentOK()
+			if !ok {
+				continue
+			}
+			history = nested
+		}
+
+		service, _ := history.Lookup("service").StringValueOK()
+		aiModel, _ := history.Lookup("aiModel").StringValueOK()
+		config := legacyAIConfiguration(service, aiModel, history.Lookup("aiConfiguration"))
+
+		_, err = col.UpdateOne(ctx, bson.M{"_id": doc.Lookup("_id")}, bson.M{"$set": bson.M{
+			prefix + "aiConfiguration": config,
+			prefix + "schemaVersion":   HistorySchemaVersion,
+		}})
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+
+	return migrated, cursor.Err()
+}
+
+// legacyAIConfiguration converts the JSON string of version 1, or the untyped document written in between.
+// The request payload stored by the Prodia edits, base64 image included, is dropped
+func legacyAIConfiguration(service, aiModel string, raw bson.RawValue) AIConfiguration {
+	values := map[string]interface{}{}
+	switch raw.Type {
+	case bson.TypeString:
+		_ = json.Unmarshal([]byte(raw.StringValue()), &values)
+	case bson.TypeEmbeddedDocument:
+		_ = raw.Unmarshal(&values)
+	}
+
+	str := func(keys ...string) string {
+		for _, key := range keys {
+			if s, ok := values[key].(string); ok && s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	num := func(keys ...string) int {
+		for _, key := range keys {
+			switch n := values[key].(type) {
+			case float64:
+				return int(n)
+			case int32:
+				return int(n)
+			case int64:
+				return int(n)
+			}
+		}
+		return 0
+	}
+	model := func() string {
+		if m := str("model"); m != "" {
+			return m
+		}
+		return aiModel
+	}
+
+	switch service {
+	case "prodia":
+		return AIConfiguration{Prodia: &ProdiaConfiguration{
+			Model:          model(),
+			Sampler:        str("sampler"),
+			Steps:          num("steps"),
+			CFGScale:       num("cfg_scale", "cfgScale"),
+			NegativePrompt: str("negative_prompt", "negativePrompt"),
+			Seed:           num("seed"),
+			Width:          num("width"),
+			Height:         num("height"),
+		}}
+	case "openai":
+		return AIConfiguration{OpenAI: &OpenAIConfiguration{
+			Model:   model(),
+			Size:    str("size"),
+			Quality: str("quality"),
+			Style:   str("style"),
+		}}
+	case "stable-diffusion":
+		return AIConfiguration{StableDiffusion: &StabilityConfiguration{
+			Model:          model(),
+			AspectRatio:    str("aspect_ratio", "aspectRatio"),
+			NegativePrompt: str("negative_prompt", "negativePrompt"),
+			Seed:           num("seed"),
+		}}
+	default:
+		return AIConfiguration{}
+	}
+}
@@ -57,6 +57,62 @@ func SetDefaultPageLimit(page *int64, limit *int64) {
 	}
 }
 
+// EnsureIndexes creates the indexes of the collections, it is safe to call on every start
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	if _, err := ColHistory(db).Indexes().CreateMany(ctx, HistoryIndexes); err != nil {
+		return fmt.Errorf("failed to create history indexes: %v", err)
+	}
+	if _, err := ColImage(db).Indexes().CreateMany(ctx, ImageIndexes); err != nil {
+		return fmt.Errorf("failed to create image indexes: %v", err)
+	}
+	if _, err := ColFeedback(db).Indexes().CreateMany(ctx, FeedbackIndexes); err != nil {
+		return fmt.Errorf("failed to create feedback indexes: %v", err)
+	}
+	if _, err := ColGenerationWinner(db).Indexes().CreateMany(ctx, GenerationWinnerIndexes); err != nil {
+		return fmt.Errorf("failed to create generation winner indexes: %v", err)
+	}
+
+	fmt.Printf("⚡️ [mongodb]: indexes ready \n")
+
+	return nil
+}
+
 func ColHistory(db *mongo.Database) *mongo.Collection {
 	return db.Collection("histories")
 }
+
+func ColProdiaJob(db *mongo.Database) *mongo.Collection {
+	return db.Collection("prodiaJobs")
+}
+
+func ColUser(db *mongo.Database) *mongo.Collection {
+	return db.Collection("users")
+}
+
+func ColAPIKey(db *mongo.Database) *mongo.Collection {
+	return db.Collection("apiKeys")
+}
+
+func ColCreditAccount(db *mongo.Database) *mongo.Collection {
+	return db.Collection("creditAccounts")
+}
+
+func ColCreditEntry(db *mongo.Database) *mongo.Collection {
+	return db.Collection("creditEntries")
+}
+
+func ColRateLimit(db *mongo.Database) *mongo.Collection {
+	return db.Collection("rateLimits")
+}
+
+func ColFeedback(db *mongo.Database) *mongo.Collection {
+	return db.Collection("feedback")
+}
+
+func ColGenerationWinner(db *mongo.Database) *mongo.Collection {
+	return db.Collection("generationWinners")
+}
+
+func ColImage(db *mongo.Database) *mongo.Collection {
+	return db.Collection("images")
+}
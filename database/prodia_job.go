@@ -0,0 +1,18 @@
+package database
+
+import "time"
+
+// ProdiaJob is a queued Prodia job which is not finished yet,
+// History holds the record to persist once the image is ready
+type ProdiaJob struct {
+	ID      string  `bson:"_id" json:"id"`
+	History History `bson:"history" json:"history"`
+
+	// Credits is what the user paid for the image, refunded if the job fails once resumed
+	Credits int64 `bson:"credits,omitempty" json:"credits,omitempty"`
+
+	// Overlay draws the text of the history on the image once it is ready
+	Overlay bool `bson:"overlay,omitempty" json:"overlay,omitempty"`
+
+	CreatedAt time.Time `bson:"createdAt" json:"createdAt"`
+}
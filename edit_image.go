@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/imaging"
+	"github.com/namhq1989/demo-ai/prodia"
+	"github.com/namhq1989/demo-ai/stablediffusion"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type editImagePayload struct {
+	// the image to edit is, by precedence, the generated image HistoryID, the file uploaded as "image"
+	// in a multipart request, the image downloaded from ImageURL or the base64 Image
+	Image     string   `json:"image" form:"image"`
+	ImageURL  string   `json:"imageUrl" form:"imageUrl"`
+	HistoryID string   `json:"historyId" form:"historyId"`
+	Prompt    string   `json:"prompt" form:"prompt"`
+	Style     string   `json:"style" form:"style"`
+	Providers []string `json:"providers" form:"providers"`
+
+	// Advanced is a JSON object in a multipart request
+	Advanced advancedParams `json:"advanced" form:"advanced"`
+
+	// set by the server
+	GenerationID primitive.ObjectID  `json:"-"`
+	UserID       string              `json:"-"`
+	ClientIP     string              `json:"-"`
+	ParentID     *primitive.ObjectID `json:"-"`
+	RootID       *primitive.ObjectID `json:"-"`
+	InputImage   *database.ImageRef  `json:"-"`
+	Charged      bool                `json:"-"`
+}
+
+func (a *app) editImage(c echo.Context) error {
+	// parse payload into editImagePayload
+	var payload editImagePayload
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	if err := payload.Advanced.validate(); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	ctx := c.Request().Context()
+
+	upload, err := a.readFormImage(c, "image")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	var image []byte
+	switch {
+	case payload.HistoryID != "":
+		source, err := a.findHistoryByID(c, payload.HistoryID)
+		if err != nil {
+			return historyError(c, err)
+		}
+		if image, err = readHistoryImage(source); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+		}
+		payload.ParentID, payload.RootID = source.Lineage()
+	case upload != nil:
+		image = upload
+	case payload.ImageURL != "":
+		if image, err = a.fetchImage(ctx, payload.ImageURL); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+		}
+	case payload.Image != "":
+		if image, err = decodeBase64Image(payload.Image); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+		}
+	default:
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "missing image, imageUrl or historyId"})
+	}
+
+	// the providers get an upright image in a format they all accept
+	if payload.HistoryID == "" {
+		if image, _, err = imaging.Normalize(image, a.uploads.Limits); err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+		}
+	}
+
+	providers, err := resolveProviders(payload.Providers, a.styles.Get(payload.Style).Providers, "", editImageProviders)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	payload.UserID = getCaller(c).ID
+	payload.ClientIP = c.RealIP()
+
+	providers, err = a.checkBudget(ctx, payload.UserID, payload.ClientIP, providers, editImageCost)
+	if errors.Is(err, errBudgetExceeded) {
+		return c.JSON(http.StatusPaymentRequired, echo.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	// the providers get the image in base64, the history only references the stored copy
+	inputImage, err := a.storeInputImage(ctx, image)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	payload.Image = base64.StdEncoding.EncodeToString(image)
+	payload.InputImage = &inputImage
+
+	payload.GenerationID = database.NewObjectID()
+
+	payload.Charged = a.usesCredits(getCaller(c))
+
+	refund, err := a.chargeImages(ctx, getCaller(c), "edit-image", providers, payload.GenerationID)
+	if errors.Is(err, errInsufficientCredits) {
+		return c.JSON(http.StatusPaymentRequired, echo.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	var (
+		wg     sync.WaitGroup
+		images = make([]imageResult, len(providers))
+	)
+	for i, provider := range providers {
+		wg.Add(1)
+		go func(i int, provider string) {
+			defer wg.Done()
+			images[i] = a.edit(ctx, provider, payload)
+		}(i, provider)
+	}
+	wg.Wait()
+
+	refund(images)
+
+	return c.JSON(http.StatusOK, echo.Map{"generationId": payload.GenerationID, "images": images})
+}
+
+// edit runs edit-image on the provider and persists the history, the provider is skipped while its breaker is open
+func (a *app) edit(ctx context.Context, provider string, payload editImagePayload) imageResult {
+	var (
+		history database.History
+		jobID   string
+	)
+
+	ticket, err := a.callProvider(ctx, provider, func() (err error) {
+		switch provider {
+		case providerStableDiffusion:
+			history, err = a.sdEditImage(payload)
+		case providerProdia:
+			history, jobID, err = a.pdEditImage(ctx, payload)
+		default:
+			err = fmt.Errorf("unknown provider: %s", provider)
+		}
+		return err
+	}, func() (err error) {
+		// the Prodia job is awaited once its vendor slot is released
+		if jobID == "" {
+			return nil
+		}
+		job := database.ProdiaJob{ID: jobID, History: history}
+		if payload.Charged {
+			job.Credits = creditCost(history.Type, providerProdia)
+		}
+		history, err = a.completeProdiaJob(ctx, job)
+		return err
+	})
+
+	result := newImageResult(provider, ticket)
+
+	fmt.Printf("*** DONE %s *** %s \n", strings.ToUpper(provider), history.Name)
+
+	if err != nil {
+		fmt.Printf("[%s] error when editing image: %s \n", strings.ToUpper(provider), err.Error())
+		if errors.Is(err, errProdiaJobPending) {
+			result.Pending = true
+			result.HistoryID = history.ID.Hex()
+		}
+		return result
+	}
+
+	history = a.finishHistory(history, false)
+
+	result.URL = history.Name
+	result.HistoryID = history.ID.Hex()
+	return result
+}
+
+func (a *app) sdEditImage(payload editImagePayload) (database.History, error) {
+	url, err := a.sd.EditImage(payload.Image, payload.Prompt)
+	if err != nil {
+		return database.History{}, err
+	}
+
+	config := database.StabilityConfiguration{Model: stablediffusion.ModelInpaint}
+
+	return database.History{
+		ID:              database.NewObjectID(),
+		GenerationID:    payload.GenerationID,
+		ParentID:        payload.ParentID,
+		RootID:          payload.RootID,
+		InputImage:      payload.InputImage,
+		Name:            url,
+		Service:         providerStableDiffusion,
+		Type:            "edit-image",
+		AIModel:         config.Model,
+		AIConfiguration: database.AIConfiguration{StableDiffusion: &config},
+		Prompt:          payload.Prompt,
+		Cost:            editImageCost(providerStableDiffusion),
+		UserID:          payload.UserID,
+		ClientIP:        payload.ClientIP,
+		CreatedAt:       time.Now(),
+	}, nil
+}
+
+// pdEditImage submits the transformation, the returned history is completed once the job is done
+func (a *app) pdEditImage(ctx context.Context, payload editImagePayload) (database.History, string, error) {
+	params := payload.Advanced.prodia(a.styles.Get(payload.Style))
+
+	data := prodia.EditImagePayload{
+		MaskBlur:            1,
+		InpaintingFullRes:   false,
+		InpaitingFill:       0,
+		InpantingMaskInvert: 0,
+		ImageData:           payload.Image,
+		// ImageURL:            "https://adeptdept.com/storage/2024/02/ai-image-prompting-101-subject-orientation-pancakes.webp",
+		Model:          params.Model,
+		Prompt:         payload.Prompt,
+		NegativePrompt: params.NegativePrompt,
+		Steps:          params.Steps,
+		CFGScale:       params.CFGScale,
+		Sampler:        params.Sampler,
+		Seed:           0,
+	}
+
+	if err := a.pd.Validate(ctx, data.Model, data.Sampler); err != nil {
+		return database.History{}, "", err
+	}
+
+	history := database.History{
+		ID:              database.NewObjectID(),
+		GenerationID:    payload.GenerationID,
+		ParentID:        payload.ParentID,
+		RootID:          payload.RootID,
+		InputImage:      payload.InputImage,
+		Service:         providerProdia,
+		Type:            "edit-image",
+		AIModel:         data.Model,
+		AIConfiguration: database.AIConfiguration{Prodia: &params},
+		Prompt:          payload.Prompt,
+		Cost:            editImageCost(providerProdia),
+		UserID:          payload.UserID,
+		ClientIP:        payload.ClientIP,
+	}
+
+	jobID, err := a.pd.SubmitEditImage(ctx, data)
+	if err != nil {
+		return database.History{}, "", err
+	}
+	return history, jobID, nil
+}
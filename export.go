@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/imaging"
+	"github.com/namhq1989/demo-ai/product"
+	"github.com/namhq1989/demo-ai/storage"
+	"github.com/namhq1989/demo-ai/util"
+)
+
+type exportPayload struct {
+	// Product defaults to the product of the history
+	Product     string `json:"product"`
+	ColorIntent string `json:"colorIntent"`
+	Crop        string `json:"crop"`
+}
+
+// exportHistory renders the print-ready file of the history for the product: the print size at the product dpi,
+// bleed included, as a PNG tagged with its resolution and color intent. The report warns when the source is too small
+func (a *app) exportHistory(c echo.Context) error {
+	var payload exportPayload
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	history, err := a.findHistory(c)
+	if err != nil {
+		return historyError(c, err)
+	}
+	if !a.canDownload(c, history) {
+		return c.JSON(http.StatusForbidden, echo.Map{"message": "history is not approved"})
+	}
+
+	if payload.Product == "" {
+		payload.Product = history.Product
+	}
+	spec, ok := product.Get(payload.Product)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "unknown product: " + payload.Product})
+	}
+
+	intent, err := imaging.ParseColorIntent(payload.ColorIntent)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	crop := imaging.CropMode(payload.Crop)
+	if crop == "" {
+		crop = imaging.CropCenter
+	}
+	if crop != imaging.CropCenter && crop != imaging.CropSmart {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "crop must be center or smart"})
+	}
+
+	original, err := readHistoryImage(history)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	img, err := imaging.Decode(original)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	out, report := imaging.Print(img, spec.Print, crop)
+
+	var buf bytes.Buffer
+	err = imaging.EncodePrintPNG(&buf, out, spec.Print.DPI, intent, map[string]string{
+		"Title":    history.ID.Hex(),
+		"Software": "demo-ai",
+		"Comment":  fmt.Sprintf("%s print, %gx%gin with %gin bleed", spec.Name, spec.Print.WidthIn, spec.Print.HeightIn, spec.Print.BleedIn),
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	object, err := storage.Save(fmt.Sprintf("%s-%s-print.png", history.ID.Hex(), spec.Name), buf.Bytes())
+	if err != nil {
+		fmt.Println("[EXPORT] error when saving export:", err.Error())
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "cannot save export"})
+	}
+
+	export := database.PrintExport{
+		Product:      spec.Name,
+		Name:         object.Name,
+		Hash:         object.Hash,
+		Size:         object.Size,
+		ColorIntent:  string(intent),
+		DPI:          spec.Print.DPI,
+		BleedIn:      spec.Print.BleedIn,
+		WidthPx:      report.WidthPx,
+		HeightPx:     report.HeightPx,
+		EffectiveDPI: report.EffectiveDPI,
+		Warnings:     report.Warnings,
+		CreatedAt:    time.Now(),
+	}
+
+	// a previous export of the product is overwritten, its cached previews are stale
+	if err = storage.RemoveVariants(object.Name); err != nil {
+		fmt.Println("[EXPORT] error when removing stale variants:", err.Error())
+	}
+
+	if _, err = a.historyRepo.SaveExport(c.Request().Context(), history.ID, export); err != nil {
+		return historyError(c, err)
+	}
+
+	expiresAt := time.Now().Add(a.previews.DownloadTTL)
+	return c.JSON(http.StatusOK, echo.Map{
+		"export":    export,
+		"report":    report,
+		"url":       util.GetSignedImageURL(object.Name, util.ImageOriginal, expiresAt),
+		"expiresAt": expiresAt,
+	})
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxRating = 5
+
+// history fields the feedback stats can be grouped by
+var mapFeedbackGroupBy = map[string]string{
+	"provider": "service",
+	"model":    "aiModel",
+	"style":    "style",
+	"product":  "product",
+}
+
+// voterID identifies the author of a feedback, anonymous callers are told apart by ip
+func voterID(c echo.Context) string {
+	if id := getCaller(c).ID; id != "" {
+		return id
+	}
+	return "ip:" + c.RealIP()
+}
+
+type feedbackPayload struct {
+	Reaction string `json:"reaction"`
+	Rating   int    `json:"rating"`
+	Comment  string `json:"comment"`
+}
+
+// giveFeedback likes, dislikes or rates a history, a new feedback of the same voter replaces the previous one
+func (a *app) giveFeedback(c echo.Context) error {
+	var payload feedbackPayload
+	if err := c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	if payload.Reaction != "" && payload.Reaction != database.ReactionLike && payload.Reaction != database.ReactionDislike {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "reaction must be like or dislike"})
+	}
+	if payload.Rating < 0 || payload.Rating > maxRating {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": fmt.Sprintf("rating must be between 1 and %d", maxRating)})
+	}
+	if payload.Reaction == "" && payload.Rating == 0 && payload.Comment == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "missing reaction, rating or comment"})
+	}
+
+	history, err := a.findHistory(c)
+	if err != nil {
+		return historyError(c, err)
+	}
+
+	var (
+		now   = time.Now()
+		set   = bson.M{"service": history.Service, "aiModel": history.AIModel, "style": history.Style, "product": history.Product, "updatedAt": now}
+		unset = bson.M{}
+	)
+	for key, value := range map[string]interface{}{"reaction": payload.Reaction, "rating": payload.Rating, "comment": payload.Comment} {
+		if value == "" || value == 0 {
+			unset[key] = ""
+		} else {
+			set[key] = value
+		}
+	}
+
+	update := bson.M{
+		"$set":         set,
+		"$setOnInsert": bson.M{"_id": database.NewObjectID(), "generationId": history.GenerationID, "createdAt": now},
+	}
+	if len(unset) > 0 {
+		update["$unset"] = unset
+	}
+
+	var feedback database.Feedback
+	err = a.colFeedback.FindOneAndUpdate(c.Request().Context(),
+		bson.M{"historyId": history.ID, "voterId": voterID(c)},
+		update,
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&feedback)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"feedback": feedback})
+}
+
+type pickWinnerPayload struct {
+	HistoryID string `json:"historyId"`
+}
+
+// pickWinner records the preferred image among the images of a generation, a new pick replaces the previous one
+func (a *app) pickWinner(c echo.Context) error {
+	generationID, err := database.ObjectIDFromString(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid generation id"})
+	}
+
+	var payload pickWinnerPayload
+	if err = c.Bind(&payload); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	history, err := a.findHistoryByID(c, payload.HistoryID)
+	if err != nil {
+		return historyError(c, err)
+	}
+	if history.GenerationID != generationID {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "history is not part of the generation"})
+	}
+
+	var winner database.GenerationWinner
+	err = a.colGenerationWinner.FindOneAndUpdate(c.Request().Context(),
+		bson.M{"generationId": generationID, "voterId": voterID(c)},
+		bson.M{
+			"$set":         bson.M{"historyId": history.ID, "createdAt": time.Now()},
+			"$setOnInsert": bson.M{"_id": database.NewObjectID()},
+		},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&winner)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"winner": winner})
+}
+
+type feedbackRow struct {
+	Key       string  `bson:"_id" json:"key"`
+	Count     int     `bson:"count" json:"count"`
+	Likes     int     `bson:"likes" json:"likes"`
+	Dislikes  int     `bson:"dislikes" json:"dislikes"`
+	Ratings   int     `bson:"ratings" json:"ratings"`
+	AvgRating float64 `bson:"avgRating" json:"avgRating"`
+}
+
+type winRateRow struct {
+	Key      string  `bson:"_id" json:"key"`
+	Contests int     `bson:"contests" json:"contests"`
+	Wins     int     `bson:"wins" json:"wins"`
+	WinRate  float64 `bson:"-" json:"winRate"`
+}
+
+// feedbackStats reports the reactions, ratings and win rates grouped by provider, model, style or product.
+// Several fields can be combined, e.g. "groupBy=style,model"
+func (a *app) feedbackStats(c echo.Context) error {
+	groupBy := c.QueryParam("groupBy")
+	if groupBy == "" {
+		groupBy = "provider"
+	}
+
+	fields := make([]string, 0)
+	for _, key := range strings.Split(groupBy, ",") {
+		field, ok := mapFeedbackGroupBy[strings.TrimSpace(key)]
+		if !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": "invalid groupBy: " + key})
+		}
+		fields = append(fields, field)
+	}
+
+	ctx := c.Request().Context()
+
+	ratings, err := a.feedbackRatings(ctx, fields)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	winRates, err := a.feedbackWinRates(ctx, fields)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"ratings": ratings, "winRates": winRates})
+}
+
+func (a *app) feedbackRatings(ctx context.Context, fields []string) ([]feedbackRow, error) {
+	pipeline := []bson.M{
+		{"$group": bson.M{
+			"_id":       groupKey("$", fields),
+			"count":     bson.M{"$sum": 1},
+			"likes":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$reaction", database.ReactionLike}}, 1, 0}}},
+			"dislikes":  bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$reaction", database.ReactionDislike}}, 1, 0}}},
+			"ratings":   bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$gt": bson.A{"$rating", 0}}, 1, 0}}},
+			"avgRating": bson.M{"$avg": "$rating"},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	rows := make([]feedbackRow, 0)
+	cursor, err := a.colFeedback.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// feedbackWinRates counts, for every pick, one contest per image of the generation and one win for the picked image.
+// Generations with a single image are not contests
+func (a *app) feedbackWinRates(ctx context.Context, fields []string) ([]winRateRow, error) {
+	pipeline := []bson.M{
+		{"$lookup": bson.M{"from": a.colHistory.Name(), "localField": "generationId", "foreignField": "generationId", "as": "candidates"}},
+		{"$match": bson.M{"candidates.1": bson.M{"$exists": true}}},
+		{"$unwind": "$candidates"},
+		{"$group": bson.M{
+			"_id":      groupKey("$candidates.", fields),
+			"contests": bson.M{"$sum": 1},
+			"wins":     bson.M{"$sum": bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$candidates._id", "$historyId"}}, 1, 0}}},
+		}},
+		{"$sort": bson.M{"_id": 1}},
+	}
+
+	rows := make([]winRateRow, 0)
+	cursor, err := a.colGenerationWinner.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &rows); err != nil {
+		return nil, err
+	}
+
+	for i := range rows {
+		if rows[i].Contests > 0 {
+			rows[i].WinRate = float64(rows[i].Wins) / float64(rows[i].Contests)
+		}
+	}
+	return rows, nil
+}
+
+// groupKey joins the fields with " / " so several fields group into a single string key
+func groupKey(prefix string, fields []string) interface{} {
+	if len(fields) == 1 {
+		return bson.M{"$ifNull": bson.A{prefix + fields[0], ""}}
+	}
+
+	parts := make(bson.A, 0, len(fields)*2)
+	for i, field := range fields {
+		if i > 0 {
+			parts = append(parts, " / ")
+		}
+		parts = append(parts, bson.M{"$ifNull": bson.A{prefix + field, ""}})
+	}
+	return bson.M{"$concat": parts}
+}
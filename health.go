@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/breaker"
+	"github.com/namhq1989/demo-ai/throttle"
+)
+
+func (a *app) health(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":    "ok",
+		"providers": a.breakers.Statuses(),
+		"queues":    a.throttleStatuses(),
+	})
+}
+
+func (a *app) throttleStatuses() []throttle.Status {
+	statuses := make([]throttle.Status, 0, len(a.throttles))
+	for _, t := range a.throttles {
+		statuses = append(statuses, t.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// metrics exposes the vendor queues and breakers in the Prometheus text format
+func (a *app) metrics(c echo.Context) error {
+	var b strings.Builder
+
+	writeMetric := func(name, help, kind string, values map[string]float64) {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+
+		providers := make([]string, 0, len(values))
+		for provider := range values {
+			providers = append(providers, provider)
+		}
+		sort.Strings(providers)
+
+		for _, provider := range providers {
+			fmt.Fprintf(&b, "%s{provider=%q} %g\n", name, provider, values[provider])
+		}
+	}
+
+	var (
+		queueDepth = make(map[string]float64)
+		active     = make(map[string]float64)
+		waitSum    = make(map[string]float64)
+		waitCount  = make(map[string]float64)
+		rejected   = make(map[string]float64)
+		open       = make(map[string]float64)
+		errorRate  = make(map[string]float64)
+	)
+	for _, s := range a.throttleStatuses() {
+		queueDepth[s.Name] = float64(s.QueueDepth)
+		active[s.Name] = float64(s.Active)
+		waitSum[s.Name] = s.TotalWaitSec
+		waitCount[s.Name] = float64(s.Waited)
+		rejected[s.Name] = float64(s.Rejected)
+	}
+	for _, s := range a.breakers.Statuses() {
+		open[s.Name] = 0
+		if s.State != breaker.StateClosed {
+			open[s.Name] = 1
+		}
+		errorRate[s.Name] = s.ErrorRate
+	}
+
+	writeMetric("demo_ai_vendor_queue_depth", "Calls waiting for a vendor slot.", "gauge", queueDepth)
+	writeMetric("demo_ai_vendor_active_calls", "Calls in flight to the vendor.", "gauge", active)
+	writeMetric("demo_ai_vendor_queue_wait_seconds_sum", "Total time spent waiting for a vendor slot.", "counter", waitSum)
+	writeMetric("demo_ai_vendor_queue_wait_seconds_count", "Calls which got a vendor slot.", "counter", waitCount)
+	writeMetric("demo_ai_vendor_queue_rejected_total", "Calls rejected because the vendor queue was full.", "counter", rejected)
+	writeMetric("demo_ai_vendor_breaker_open", "1 when the breaker of the vendor is not closed.", "gauge", open)
+	writeMetric("demo_ai_vendor_breaker_error_rate", "Error rate of the latest calls to the vendor.", "gauge", errorRate)
+
+	var storeErrors int64
+	if a.rateLimitStore != nil {
+		storeErrors = a.rateLimitStore.Failures()
+	}
+	fmt.Fprintf(&b, "# HELP demo_ai_rate_limit_store_errors_total Hits counted in process because the shared store failed.\n# TYPE demo_ai_rate_limit_store_errors_total counter\ndemo_ai_rate_limit_store_errors_total %d\n", storeErrors)
+	fmt.Fprintf(&b, "# HELP demo_ai_rate_limit_fail_open_total Requests let through because the limit could not be checked.\n# TYPE demo_ai_rate_limit_fail_open_total counter\ndemo_ai_rate_limit_fail_open_total %d\n", rateLimitFailOpen.Load())
+
+	return c.String(http.StatusOK, b.String())
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/storage"
+	"github.com/namhq1989/demo-ai/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// histories returns a page of the histories of the caller, admins may see every user or pick one with "userId".
+// Pages are chained with "cursor", the "nextCursor" of the previous page, "deleted=true" lists the deleted histories
+func (a *app) histories(c echo.Context) error {
+	filter, err := parseHistoryFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	if user := getCaller(c); a.auth.Enabled {
+		if !user.isAdmin() {
+			filter.UserID = user.ID
+		} else {
+			filter.UserID = c.QueryParam("userId")
+		}
+	}
+
+	var page, limit int64 = 0, 50
+	if s := c.QueryParam("limit"); s != "" {
+		if limit, err = strconv.ParseInt(s, 10, 64); err != nil || limit == 0 {
+			limit = -1
+		}
+	}
+	database.SetDefaultPageLimit(&page, &limit)
+
+	// fetch one more to know whether there is a next page
+	histories, err := a.historyRepo.List(c.Request().Context(), filter, limit+1)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	nextCursor := ""
+	if int64(len(histories)) > limit {
+		histories = histories[:limit]
+		nextCursor = histories[limit-1].ID.Hex()
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"histories": histories, "nextCursor": nextCursor})
+}
+
+func parseHistoryFilter(c echo.Context) (database.HistoryFilter, error) {
+	filter := database.HistoryFilter{
+		Service: c.QueryParam("service"),
+		Type:    c.QueryParam("type"),
+		Product: c.QueryParam("product"),
+		Style:   c.QueryParam("style"),
+		AIModel: c.QueryParam("model"),
+		Search:  c.QueryParam("q"),
+		Deleted: c.QueryParam("deleted") == "true",
+	}
+
+	if s := c.QueryParam("cursor"); s != "" {
+		id, err := database.ObjectIDFromString(s)
+		if err != nil {
+			return filter, errors.New("invalid cursor")
+		}
+		filter.Cursor = id
+	}
+
+	var err error
+	if filter.From, err = parseTimeParam(c.QueryParam("from"), false); err != nil {
+		return filter, errors.New("invalid from date")
+	}
+	if filter.To, err = parseTimeParam(c.QueryParam("to"), true); err != nil {
+		return filter, errors.New("invalid to date")
+	}
+
+	return filter, nil
+}
+
+// parseTimeParam accepts RFC3339 or YYYY-MM-DD, a date used as an end bound includes the whole day
+func parseTimeParam(s string, end bool) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	t, err := time.Parse(time.DateOnly, s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// findHistory returns the history of the "id" param, the histories of other users are not found unless the caller is an admin
+func (a *app) findHistory(c echo.Context) (database.History, error) {
+	return a.findHistoryByID(c, c.Param("id"))
+}
+
+func (a *app) findHistoryByID(c echo.Context, historyID string) (database.History, error) {
+	id, err := database.ObjectIDFromString(historyID)
+	if err != nil {
+		return database.History{}, errors.New("invalid history id")
+	}
+
+	history, err := a.historyRepo.Get(c.Request().Context(), id)
+	if err != nil {
+		return history, err
+	}
+
+	if user := getCaller(c); a.auth.Enabled && !user.isAdmin() && history.UserID != user.ID {
+		return database.History{}, database.ErrHistoryNotFound
+	}
+	return history, nil
+}
+
+func historyError(c echo.Context, err error) error {
+	if errors.Is(err, database.ErrHistoryNotFound) {
+		return c.JSON(http.StatusNotFound, echo.Map{"message": err.Error()})
+	}
+	return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+}
+
+func (a *app) history(c echo.Context) error {
+	history, err := a.findHistory(c)
+	if err != nil {
+		return historyError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"history": history})
+}
+
+// deleteHistory soft deletes the history, its image is kept until the retention window passes
+func (a *app) deleteHistory(c echo.Context) error {
+	return a.updateHistoryStatus(c, database.HistoryStatusDeleted)
+}
+
+func (a *app) restoreHistory(c echo.Context) error {
+	return a.updateHistoryStatus(c, database.HistoryStatusActive)
+}
+
+func (a *app) updateHistoryStatus(c echo.Context, status database.HistoryStatus) error {
+	history, err := a.findHistory(c)
+	if err != nil {
+		return historyError(c, err)
+	}
+
+	history, err = a.historyRepo.UpdateStatus(c.Request().Context(), history.ID, status)
+	if err != nil {
+		return historyError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"history": history})
+}
+
+// readHistoryImage returns the image of the history
+func readHistoryImage(history database.History) ([]byte, error) {
+	name := util.GetImageName(history.Name)
+	if name == "" {
+		return nil, errors.New("history has no image")
+	}
+
+	b, err := storage.Read(name)
+	if err != nil {
+		return nil, errors.New("history image is no longer available")
+	}
+	return b, nil
+}
+
+// variations renders new images of a text-to-image history with other seeds, on its provider unless "providers" is set
+func (a *app) variations(c echo.Context) error {
+	source, err := a.findHistory(c)
+	if err != nil {
+		return historyError(c, err)
+	}
+	if source.Type != "text-to-image" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "only text-to-image histories have variations"})
+	}
+
+	in := textToImageInput{
+		Prompt:             source.Prompt,
+		Description:        source.Description,
+		Style:              source.Style,
+		ColorScheme:        source.ColorScheme,
+		Text:               source.Text,
+		TextStyle:          source.TextStyle,
+		Layout:             source.Layout,
+		Theme:              source.Theme,
+		AdditionalElements: source.AdditionalElements,
+		Product:            source.Product,
+		TextMode:           textModePrompt,
+		UserID:             getCaller(c).ID,
+		ClientIP:           c.RealIP(),
+	}
+	in.ParentID, in.RootID = source.Lineage()
+
+	// the prompt of an overlay has no text, the variations get the text drawn too
+	if source.TextOverlay != nil {
+		in.TextMode = textModeOverlay
+	}
+
+	if in.Advanced, err = parseAdvancedParams(c.QueryParam("advanced")); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	providers := splitProviders(c.QueryParam("providers"))
+	if len(providers) == 0 {
+		providers = []string{source.Service}
+	}
+
+	return a.generateImages(c, in, providers)
+}
+
+type historyNode struct {
+	database.History
+	Children []*historyNode `json:"children"`
+}
+
+// lineage returns the tree of the histories derived from the root of the history,
+// the children of a deleted history are attached to the root.
+// When the root is missing, removed before the histories were kept, the oldest history of the tree still present stands for it
+func (a *app) lineage(c echo.Context) error {
+	history, err := a.findHistory(c)
+	if err != nil {
+		return historyError(c, err)
+	}
+
+	ctx := c.Request().Context()
+
+	rootID := history.ID
+	if history.RootID != nil {
+		rootID = *history.RootID
+	}
+
+	derived, err := a.historyRepo.List(ctx, database.HistoryFilter{RootID: rootID}, 0)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	root, err := a.historyRepo.Get(ctx, rootID)
+	if errors.Is(err, database.ErrHistoryNotFound) {
+		// List returns the newest first, a deleted history is not listed so derived may be empty
+		root = history
+		if len(derived) > 0 {
+			root = derived[len(derived)-1]
+		}
+
+		others := make([]database.History, 0, len(derived))
+		for _, h := range derived {
+			if h.ID != root.ID {
+				others = append(others, h)
+			}
+		}
+		derived = others
+	} else if err != nil {
+		return historyError(c, err)
+	}
+
+	nodes := map[primitive.ObjectID]*historyNode{root.ID: {History: root, Children: make([]*historyNode, 0)}}
+	for _, h := range derived {
+		nodes[h.ID] = &historyNode{History: h, Children: make([]*historyNode, 0)}
+	}
+
+	// List returns the newest first, walk backwards so the children are in creation order
+	for i := len(derived) - 1; i >= 0; i-- {
+		h := derived[i]
+		parent := nodes[root.ID]
+		if h.ParentID != nil {
+			if p, ok := nodes[*h.ParentID]; ok {
+				parent = p
+			}
+		}
+		parent.Children = append(parent.Children, nodes[h.ID])
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"lineage": nodes[root.ID]})
+}
+
+// sweepHistories removes the images of the histories deleted longer ago than retention, the histories are kept.
+// It runs every interval until ctx is done
+func (a *app) sweepHistories(ctx context.Context, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := a.sweepDeletedHistories(ctx, time.Now().Add(-retention)); err != nil {
+			fmt.Println("[HISTORY] error when sweeping deleted histories:", err.Error())
+		} else if n > 0 {
+			fmt.Printf("[HISTORY] swept the files of %d deleted histories \n", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *app) sweepDeletedHistories(ctx context.Context, before time.Time) (int, error) {
+	histories, err := a.historyRepo.List(ctx, database.HistoryFilter{DeletedBefore: before}, 0)
+	if err != nil {
+		return 0, err
+	}
+
+	swept := 0
+	for _, history := range histories {
+		if err = removeHistoryFiles(history); err != nil {
+			// keep the history so the files are retried on the next sweep
+			fmt.Println("[HISTORY] error when removing image:", err.Error())
+			continue
+		}
+
+		// the history is kept, the spend reports, the feedback and the lineage still refer to it
+		if err = a.historyRepo.MarkFilesSwept(ctx, history.ID); err != nil && !errors.Is(err, database.ErrHistoryNotFound) {
+			return swept, err
+		}
+		swept++
+	}
+	return swept, nil
+}
+
+// removeHistoryFiles deletes the image of the history, its text overlay base, its exports and their cached variants
+func removeHistoryFiles(history database.History) error {
+	names := make([]string, 0, len(history.Exports)+1)
+	if name := util.GetImageName(history.Name); name != "" {
+		names = append(names, name)
+	}
+	if history.TextOverlay != nil {
+		if name := util.GetImageName(history.TextOverlay.Base); name != "" {
+			names = append(names, name)
+		}
+	}
+	for _, export := range history.Exports {
+		names = append(names, export.Name)
+	}
+
+	var errs []error
+	for _, name := range names {
+		errs = append(errs, storage.Remove(name), storage.RemoveVariants(name))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/storage"
+	"github.com/namhq1989/demo-ai/util"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func newTestApp() *app {
+	return &app{
+		historyRepo: database.NewMemoryHistoryRepository(),
+		auth:        authConfig{Enabled: true},
+	}
+}
+
+func newTestContext(method, target string, user caller) (echo.Context, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(method, target, nil), rec)
+	c.Set(ctxKeyCaller, user)
+	return c, rec
+}
+
+func createHistory(t *testing.T, a *app, history database.History) database.History {
+	t.Helper()
+
+	if history.ID.IsZero() {
+		history.ID = database.NewObjectID()
+	}
+	if history.CreatedAt.IsZero() {
+		history.CreatedAt = time.Now()
+	}
+	if err := a.historyRepo.Create(context.Background(), history); err != nil {
+		t.Fatal(err)
+	}
+	return history
+}
+
+type historiesResponse struct {
+	Histories  []database.History `json:"histories"`
+	NextCursor string             `json:"nextCursor"`
+}
+
+func TestHistoriesPagesTheHistoriesOfTheCaller(t *testing.T) {
+	a := newTestApp()
+
+	own := make([]database.History, 3)
+	for i := range own {
+		own[i] = createHistory(t, a, database.History{UserID: "alice"})
+	}
+	createHistory(t, a, database.History{UserID: "bob"})
+
+	var pages [][]database.History
+	cursor := ""
+	for {
+		c, rec := newTestContext(http.MethodGet, "/histories?limit=2&cursor="+cursor, caller{ID: "alice"})
+		if err := a.histories(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+		}
+
+		var res historiesResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		pages = append(pages, res.Histories)
+
+		if res.NextCursor == "" {
+			break
+		}
+		cursor = res.NextCursor
+	}
+
+	if len(pages) != 2 || len(pages[0]) != 2 || len(pages[1]) != 1 {
+		t.Fatalf("pages = %v, want 2 then 1 histories", pages)
+	}
+
+	// newest first, without the histories of other users
+	want := []primitive.ObjectID{own[2].ID, own[1].ID, own[0].ID}
+	got := append(pages[0], pages[1]...)
+	for i, h := range got {
+		if h.ID != want[i] || h.UserID != "alice" {
+			t.Fatalf("history %d = %s of %q, want %s of alice", i, h.ID.Hex(), h.UserID, want[i].Hex())
+		}
+	}
+}
+
+func TestHistoriesListsTheDeletedHistories(t *testing.T) {
+	a := newTestApp()
+
+	deleted := createHistory(t, a, database.History{UserID: "alice"})
+	createHistory(t, a, database.History{UserID: "alice"})
+	if _, err := a.historyRepo.UpdateStatus(context.Background(), deleted.ID, database.HistoryStatusDeleted); err != nil {
+		t.Fatal(err)
+	}
+
+	c, rec := newTestContext(http.MethodGet, "/histories?deleted=true", caller{ID: "alice"})
+	if err := a.histories(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var res historiesResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Histories) != 1 || res.Histories[0].ID != deleted.ID {
+		t.Fatalf("histories = %v, want only %s", res.Histories, deleted.ID.Hex())
+	}
+}
+
+func TestHistoriesRejectsAnInvalidCursor(t *testing.T) {
+	a := newTestApp()
+
+	c, rec := newTestContext(http.MethodGet, "/histories?cursor=nope", caller{ID: "alice"})
+	if err := a.histories(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+type lineageResponse struct {
+	Lineage *historyNode `json:"lineage"`
+}
+
+func getLineage(t *testing.T, a *app, id primitive.ObjectID, user caller) (*httptest.ResponseRecorder, *historyNode) {
+	t.Helper()
+
+	c, rec := newTestContext(http.MethodGet, "/histories/"+id.Hex()+"/lineage", user)
+	c.SetParamNames("id")
+	c.SetParamValues(id.Hex())
+	if err := a.lineage(c); err != nil {
+		t.Fatal(err)
+	}
+
+	var res lineageResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec, res.Lineage
+}
+
+func TestLineageBuildsTheTreeFromTheRoot(t *testing.T) {
+	a := newTestApp()
+
+	root := createHistory(t, a, database.History{UserID: "alice"})
+	child := createHistory(t, a, database.History{UserID: "alice", ParentID: &root.ID, RootID: &root.ID})
+	grandchild := createHistory(t, a, database.History{UserID: "alice", ParentID: &child.ID, RootID: &root.ID})
+	sibling := createHistory(t, a, database.History{UserID: "alice", ParentID: &root.ID, RootID: &root.ID})
+
+	// any history of the tree returns the whole tree
+	rec, tree := getLineage(t, a, grandchild.ID, caller{ID: "alice"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	if tree.ID != root.ID || len(tree.Children) != 2 {
+		t.Fatalf("root = %s with %d children, want %s with 2", tree.ID.Hex(), len(tree.Children), root.ID.Hex())
+	}
+	if tree.Children[0].ID != child.ID || tree.Children[1].ID != sibling.ID {
+		t.Fatalf("children are not in creation order")
+	}
+	if len(tree.Children[0].Children) != 1 || tree.Children[0].Children[0].ID != grandchild.ID {
+		t.Fatalf("grandchild is not attached to its parent")
+	}
+}
+
+func TestLineageAttachesTheOrphansToTheRoot(t *testing.T) {
+	a := newTestApp()
+
+	root := createHistory(t, a, database.History{UserID: "alice"})
+	missing := database.NewObjectID()
+	orphan := createHistory(t, a, database.History{UserID: "alice", ParentID: &missing, RootID: &root.ID})
+
+	_, tree := getLineage(t, a, root.ID, caller{ID: "alice"})
+	if len(tree.Children) != 1 || tree.Children[0].ID != orphan.ID {
+		t.Fatalf("orphan is not attached to the root")
+	}
+}
+
+func TestLineageFallsBackToTheOldestHistoryWhenTheRootIsMissing(t *testing.T) {
+	a := newTestApp()
+
+	root := database.NewObjectID()
+	child := createHistory(t, a, database.History{UserID: "alice", ParentID: &root, RootID: &root})
+	grandchild := createHistory(t, a, database.History{UserID: "alice", ParentID: &child.ID, RootID: &root})
+	sibling := createHistory(t, a, database.History{UserID: "alice", ParentID: &root, RootID: &root})
+
+	rec, tree := getLineage(t, a, grandchild.ID, caller{ID: "alice"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+
+	if tree.ID != child.ID || len(tree.Children) != 2 {
+		t.Fatalf("root = %s with %d children, want %s with 2", tree.ID.Hex(), len(tree.Children), child.ID.Hex())
+	}
+	if tree.Children[0].ID != grandchild.ID || tree.Children[1].ID != sibling.ID {
+		t.Fatalf("children are not in creation order")
+	}
+}
+
+func TestLineageHidesTheHistoriesOfOtherUsers(t *testing.T) {
+	a := newTestApp()
+
+	root := createHistory(t, a, database.History{UserID: "alice"})
+
+	rec, _ := getLineage(t, a, root.ID, caller{ID: "bob"})
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+// useStorageDir runs the test in a temporary directory, storage.Dir is relative to the working directory
+func useStorageDir(t *testing.T) {
+	t.Helper()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+
+	if err = os.MkdirAll(storage.VariantDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSweepDeletedHistoriesRemovesTheFilesOfTheExpiredHistories(t *testing.T) {
+	useStorageDir(t)
+
+	a := newTestApp()
+	ctx := context.Background()
+
+	saveImage := func(name string) string {
+		if _, err := storage.Save(name, []byte("image")); err != nil {
+			t.Fatal(err)
+		}
+		return util.GetImageURL(name)
+	}
+
+	var (
+		cutoff      = time.Now().Add(-24 * time.Hour)
+		deletedLong = cutoff.Add(-time.Hour)
+		deletedNow  = time.Now()
+	)
+
+	expired := createHistory(t, a, database.History{Name: saveImage("expired.png"), DeletedAt: &deletedLong})
+	recent := createHistory(t, a, database.History{Name: saveImage("recent.png"), DeletedAt: &deletedNow})
+	active := createHistory(t, a, database.History{Name: saveImage("active.png")})
+
+	variant := filepath.Join(storage.VariantDir, storage.VariantName("expired.png", "w256", ".webp"))
+	if err := os.WriteFile(variant, []byte("variant"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := a.sweepDeletedHistories(ctx, cutoff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("swept = %d, want 1", n)
+	}
+
+	// the history is kept for the reports, only its files are gone
+	swept, err := a.historyRepo.Get(ctx, expired.ID)
+	if err != nil {
+		t.Fatalf("expired history was removed: %v", err)
+	}
+	if swept.FilesSweptAt == nil {
+		t.Fatalf("expired history is not marked as swept")
+	}
+	for _, name := range []string{storage.Path("expired.png"), variant} {
+		if _, err = os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("%s was not removed: %v", name, err)
+		}
+	}
+
+	for _, h := range []database.History{recent, active} {
+		if h, err = a.historyRepo.Get(ctx, h.ID); err != nil || h.FilesSweptAt != nil {
+			t.Fatalf("history %s was swept: %v", h.ID.Hex(), err)
+		}
+		if _, err = os.Stat(storage.Path(util.GetImageName(h.Name))); err != nil {
+			t.Fatalf("image of %s was removed: %v", h.ID.Hex(), err)
+		}
+	}
+}
+
+func TestSweepDeletedHistoriesSkipsTheSweptHistories(t *testing.T) {
+	useStorageDir(t)
+
+	a := newTestApp()
+	ctx := context.Background()
+
+	deletedAt := time.Now().Add(-48 * time.Hour)
+	history := createHistory(t, a, database.History{DeletedAt: &deletedAt})
+
+	for i, want := range []int{1, 0} {
+		n, err := a.sweepDeletedHistories(ctx, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != want {
+			t.Fatalf("sweep %d: swept = %d, want %d", i, n, want)
+		}
+	}
+
+	// the images are gone, the history cannot come back
+	if _, err := a.historyRepo.UpdateStatus(ctx, history.ID, database.HistoryStatusActive); !errors.Is(err, database.ErrHistoryNotFound) {
+		t.Fatalf("swept history was restored: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package httpclient
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"time"
+)
+
+type Config struct {
+	// Timeout is the overall deadline of a request, including every retry
+	Timeout time.Duration
+
+	// MaxRetries is the number of extra attempts after the first one
+	MaxRetries int
+
+	// BaseDelay is the backoff of the first retry, it doubles on every attempt until MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Timeout:    60 * time.Second,
+		MaxRetries: 3,
+		BaseDelay:  500 * time.Millisecond,
+		MaxDelay:   10 * time.Second,
+	}
+}
+
+// New returns a http client which retries on network errors, 429 and 5xx responses
+func New(cfg Config) *http.Client {
+	return &http.Client{
+		Timeout: cfg.Timeout,
+		Transport: &retryTransport{
+			next: http.DefaultTransport,
+			cfg:  cfg,
+		},
+	}
+}
+
+type retryTransport struct {
+	next http.RoundTripper
+	cfg  Config
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var (
+		resp     *http.Response
+		err      error
+		attempts int
+	)
+
+	for attempt := 0; ; attempt++ {
+		// wrote tells whether the vendor may have received the request, and acted on it
+		var wrote bool
+		trace := &httptrace.ClientTrace{WroteRequest: func(httptrace.WroteRequestInfo) { wrote = true }}
+
+		// a RoundTripper must not modify the request, every attempt sends a clone
+		attemptReq := req.Clone(httptrace.WithClientTrace(req.Context(), trace))
+		if attempt > 0 && req.Body != nil && req.GetBody != nil {
+			// the body was consumed by the previous attempt, rewind it
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			attemptReq.Body = body
+		}
+
+		attempts++
+		resp, err = t.next.RoundTrip(attemptReq)
+
+		if attempt >= t.cfg.MaxRetries || !shouldRetry(req, resp, err, wrote) {
+			break
+		}
+
+		// a request body which cannot be rewound cannot be sent twice
+		if req.Body != nil && req.GetBody == nil {
+			break
+		}
+
+		delay := t.backoff(attempt, resp)
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+
+	if err != nil {
+		return nil, &RetryError{Attempts: attempts, Err: err}
+	}
+
+	return resp, nil
+}
+
+// shouldRetry only sends a non-idempotent request again when the vendor did not act on it:
+// it failed before being written or was rejected with 429. A POST which failed after being written,
+// or with a 5xx, may have started a billed job
+func shouldRetry(req *http.Request, resp *http.Response, err error, wrote bool) bool {
+	if err != nil {
+		// the caller gave up, no need to try again
+		if req.Context().Err() != nil || errors.Is(err, context.Canceled) {
+			return false
+		}
+		return !wrote || isIdempotent(req)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return isIdempotent(req) && IsRetryableStatus(resp.StatusCode)
+}
+
+// isIdempotent follows net/http: the idempotent methods, or a request carrying an idempotency key
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// IsRetryableStatus reports whether the vendor may accept the same request later
+func IsRetryableStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= http.StatusInternalServerError
+}
+
+// backoff returns the delay before the next attempt,
+// the "Retry-After" header wins over the exponential backoff with full jitter
+func (t *retryTransport) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if d, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if d > t.cfg.MaxDelay {
+				return t.cfg.MaxDelay
+			}
+			return d
+		}
+	}
+
+	d := t.cfg.BaseDelay << attempt
+	if d <= 0 || d > t.cfg.MaxDelay {
+		d = t.cfg.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)) + 1)
+}
+
+// parseRetryAfter supports both formats of the header: seconds and http date
+func parseRetryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if at, err := http.ParseTime(v); err == nil {
+		d := time.Until(at)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+
+	return 0, false
+}
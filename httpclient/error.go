@@ -0,0 +1,52 @@
+package httpclient
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// StatusError is returned when the vendor responds with a non-2xx status code
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("non-2xx status code: %d, body: %s", e.StatusCode, e.Body)
+}
+
+// Retryable reports whether the request failed because of a temporary vendor issue
+func (e *StatusError) Retryable() bool {
+	return IsRetryableStatus(e.StatusCode)
+}
+
+// RetryError is returned when the request still fails at network level after every attempt
+type RetryError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *RetryError) Error() string {
+	return fmt.Sprintf("request failed after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetryError) Unwrap() error {
+	return e.Err
+}
+
+// ReadBody reads and closes the response body, non-2xx responses are returned as *StatusError
+func ReadBody(resp *http.Response) ([]byte, error) {
+	defer func() { _ = resp.Body.Close() }()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+
+	return body, nil
+}
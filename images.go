@@ -0,0 +1,233 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/imaging"
+	"github.com/namhq1989/demo-ai/product"
+	"github.com/namhq1989/demo-ai/storage"
+	"github.com/namhq1989/demo-ai/util"
+	"golang.org/x/sync/singleflight"
+)
+
+// renders dedupes the concurrent renders of the same variant
+var renders singleflight.Group
+
+type previewConfig struct {
+	// Watermark marks the images served without a signed url, a disabled watermark serves the originals
+	Watermark imaging.Watermark
+
+	// DownloadTTL is the lifetime of the signed urls of the originals
+	DownloadTTL time.Duration
+}
+
+// image serves a stored image, with a variant query, e.g. "?w=512&fmt=webp", it serves the variant.
+// "product" crops to the aspect ratio of the product unless "ratio" is set, "q" is only accepted with jpeg.
+// The image is watermarked unless the url is signed for the original, see util.GetSignedImageURL
+func (a *app) image(c echo.Context) error {
+	var (
+		name      = c.Param("name")
+		query     = c.QueryParams()
+		watermark = a.previews.Watermark
+		cache     = "public, max-age=86400"
+	)
+
+	if query.Has("sig") {
+		variant, err := util.VerifyImageURL(name, query)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, echo.Map{"message": err.Error()})
+		}
+		if variant == util.ImageOriginal {
+			watermark = imaging.Watermark{}
+			exp, _ := strconv.ParseInt(query.Get("exp"), 10, 64)
+			cache = "private, max-age=" + strconv.FormatInt(max(0, exp-time.Now().Unix()), 10)
+		}
+		query.Del("v")
+		query.Del("exp")
+		query.Del("sig")
+	}
+
+	if len(query) == 0 && !watermark.Enabled() {
+		c.Response().Header().Set("Cache-Control", cache)
+		return c.File(storage.Path(name))
+	}
+
+	if query.Get("product") != "" && query.Get("ratio") == "" {
+		spec, ok := product.Get(query.Get("product"))
+		if !ok {
+			return c.JSON(http.StatusBadRequest, echo.Map{"message": "unknown product: " + query.Get("product")})
+		}
+		query.Set("ratio", spec.Ratio.String())
+	}
+
+	variant, err := imaging.ParseVariant(query, imaging.FormatOf(mapImageExtContentType(name)))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	data, err := renderVariant(name, variant, watermark)
+	if errors.Is(err, os.ErrNotExist) {
+		return echo.ErrNotFound
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	c.Response().Header().Set("Cache-Control", cache)
+	return c.Blob(http.StatusOK, variant.Format.ContentType(), data)
+}
+
+// renderVariant returns the variant of the image, watermarked when the watermark is enabled,
+// it is rendered on the first request then read from the cache
+func renderVariant(name string, variant imaging.Variant, watermark imaging.Watermark) ([]byte, error) {
+	variantKey := variant.Key()
+	if watermark.Enabled() {
+		variantKey += "-" + watermark.Key()
+	}
+
+	key := storage.VariantName(name, variantKey, variant.Format.Ext())
+	if data, err := storage.ReadVariant(key); err == nil {
+		return data, nil
+	}
+
+	data, err, _ := renders.Do(key, func() (interface{}, error) {
+		original, err := storage.Read(name)
+		if err != nil {
+			return nil, err
+		}
+
+		img, err := imaging.Decode(original)
+		if err != nil {
+			return nil, err
+		}
+
+		data, err := imaging.EncodeBytes(watermark.Apply(variant.Apply(img)), variant.Format, variant.Quality)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = storage.SaveVariant(key, data); err != nil {
+			fmt.Println("[IMAGE] error when caching variant:", err.Error())
+		}
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+// thumbnailURL returns the url of the thumbnail of a stored image
+func thumbnailURL(url string) string {
+	if util.GetImageName(url) == "" {
+		return ""
+	}
+	return url + "?" + imaging.ThumbnailVariant.Query().Encode()
+}
+
+// renderThumbnail renders the thumbnail of a stored image ahead of its first request
+func (a *app) renderThumbnail(url string) {
+	name := util.GetImageName(url)
+	if name == "" {
+		return
+	}
+
+	go func() {
+		if _, err := renderVariant(name, imaging.ThumbnailVariant, a.previews.Watermark); err != nil {
+			fmt.Println("[IMAGE] error when rendering thumbnail:", err.Error())
+		}
+	}()
+}
+
+// fitToProduct crops the image of the history to the aspect ratio of its product, the subject is kept with the smart crop.
+// The providers only get close to the ratio, the image is not scaled up since the print export sizes it for the product
+func fitToProduct(history database.History) error {
+	spec, ok := product.Get(history.Product)
+	name := util.GetImageName(history.Name)
+	if !ok || name == "" {
+		return nil
+	}
+
+	data, err := storage.Read(name)
+	if err != nil {
+		return err
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return err
+	}
+
+	fitted := imaging.Crop(img, spec.Ratio, imaging.CropSmart)
+	if fitted.Bounds().Size() == img.Bounds().Size() {
+		return nil
+	}
+
+	format := imaging.FormatOf(http.DetectContentType(data))
+	out, err := imaging.EncodeBytes(fitted, format, 92)
+	if err != nil {
+		return err
+	}
+	_, err = storage.Save(name, out)
+	return err
+}
+
+// download returns a signed url of the clean image of the history, it expires after the download ttl.
+// The owner can only download approved histories
+func (a *app) download(c echo.Context) error {
+	history, err := a.findHistory(c)
+	if err != nil {
+		return historyError(c, err)
+	}
+
+	if !a.canDownload(c, history) {
+		return c.JSON(http.StatusForbidden, echo.Map{"message": "history is not approved"})
+	}
+
+	name := util.GetImageName(history.Name)
+	if name == "" {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": "history has no image"})
+	}
+
+	expiresAt := time.Now().Add(a.previews.DownloadTTL)
+	return c.JSON(http.StatusOK, echo.Map{
+		"url":       util.GetSignedImageURL(name, util.ImageOriginal, expiresAt),
+		"expiresAt": expiresAt,
+	})
+}
+
+// canDownload reports whether the caller can get the clean files of the history, admins always can
+func (a *app) canDownload(c echo.Context, history database.History) bool {
+	return history.ApprovedAt != nil || getCaller(c).isAdmin()
+}
+
+// approveHistory releases the clean image of the history to its owner
+func (a *app) approveHistory(c echo.Context) error {
+	history, err := a.findHistory(c)
+	if err != nil {
+		return historyError(c, err)
+	}
+
+	history, err = a.historyRepo.Approve(c.Request().Context(), history.ID)
+	if err != nil {
+		return historyError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"history": history})
+}
+
+// mapImageExtContentType is the inverse of mapImageContentTypeExt
+func mapImageExtContentType(name string) string {
+	for contentType, ext := range mapImageContentTypeExt {
+		if strings.HasSuffix(name, ext) {
+			return contentType
+		}
+	}
+	return imaging.MIMEJPEG
+}
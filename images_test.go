@@ -0,0 +1,50 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/imaging"
+	"github.com/namhq1989/demo-ai/storage"
+	"github.com/namhq1989/demo-ai/util"
+)
+
+func TestFitToProductCropsTheImageToTheProductRatio(t *testing.T) {
+	useStorageDir(t)
+
+	data, err := imaging.EncodeBytes(image.NewNRGBA(image.Rect(0, 0, 300, 300)), imaging.FormatPNG, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		product string
+		w, h    int
+	}{
+		{product: "poster", w: 200, h: 300},
+		{product: "mug", w: 300, h: 300},
+		{product: "", w: 300, h: 300},
+	} {
+		name := "fit-" + tc.product + ".png"
+		if _, err = storage.Save(name, data); err != nil {
+			t.Fatal(err)
+		}
+
+		if err = fitToProduct(database.History{Name: util.GetImageURL(name), Product: tc.product}); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := storage.Read(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		img, err := imaging.Decode(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size.X != tc.w || size.Y != tc.h {
+			t.Fatalf("%q: size = %v, want %dx%d", tc.product, size, tc.w, tc.h)
+		}
+	}
+}
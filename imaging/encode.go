@@ -0,0 +1,95 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+
+	"github.com/HugoSmits86/nativewebp"
+)
+
+type Format string
+
+const (
+	FormatJPEG Format = "jpeg"
+	FormatPNG  Format = "png"
+
+	// FormatWebP is lossless, the quality does not apply
+	FormatWebP Format = "webp"
+)
+
+const DefaultQuality = 85
+
+// ParseFormat parses the format name, "jpg" is an alias of jpeg
+func ParseFormat(s string) (Format, error) {
+	switch s {
+	case "jpeg", "jpg":
+		return FormatJPEG, nil
+	case "png":
+		return FormatPNG, nil
+	case "webp":
+		return FormatWebP, nil
+	}
+	return "", errors.New("unsupported format: " + s)
+}
+
+// FormatOf returns the format of a detected content type, jpeg when unknown
+func FormatOf(contentType string) Format {
+	switch contentType {
+	case MIMEPNG:
+		return FormatPNG
+	case MIMEWebP:
+		return FormatWebP
+	}
+	return FormatJPEG
+}
+
+func (f Format) Ext() string {
+	return "." + string(f)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatPNG:
+		return MIMEPNG
+	case FormatWebP:
+		return MIMEWebP
+	}
+	return MIMEJPEG
+}
+
+// Encode writes the image in the format, quality from 1 to 100 only applies to jpeg, png and webp are lossless
+func Encode(w io.Writer, img image.Image, format Format, quality int) error {
+	switch format {
+	case FormatPNG:
+		return png.Encode(w, img)
+	case FormatWebP:
+		return nativewebp.Encode(w, img, nil)
+	}
+
+	if quality <= 0 || quality > 100 {
+		quality = DefaultQuality
+	}
+	return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+}
+
+// EncodeBytes is Encode into a byte slice
+func EncodeBytes(img image.Image, format Format, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, img, format, quality); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode decodes a jpeg, png or webp image
+func Decode(data []byte) (image.Image, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Join(ErrUnsupportedFormat, err)
+	}
+	return img, nil
+}
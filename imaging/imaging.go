@@ -0,0 +1,135 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"net/http"
+	"slices"
+
+	// registers the WebP decoder
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	MIMEJPEG = "image/jpeg"
+	MIMEPNG  = "image/png"
+	MIMEWebP = "image/webp"
+)
+
+var (
+	ErrTooLarge           = errors.New("image is too large")
+	ErrUnsupportedFormat  = errors.New("unsupported image format")
+	ErrDimensionsTooLarge = errors.New("image dimensions are too large")
+)
+
+// Limits bound the images accepted from the clients, 0 means no limit
+type Limits struct {
+	MaxBytes     int64
+	MaxDimension int
+	AllowedTypes []string
+}
+
+func DefaultLimits() Limits {
+	return Limits{
+		MaxBytes:     10 << 20,
+		MaxDimension: 4096,
+		AllowedTypes: []string{MIMEJPEG, MIMEPNG, MIMEWebP},
+	}
+}
+
+// Validate checks the size, the type detected from the content and the dimensions, without decoding the pixels
+func Validate(data []byte, limits Limits) (contentType string, err error) {
+	if limits.MaxBytes > 0 && int64(len(data)) > limits.MaxBytes {
+		return "", fmt.Errorf("%w: %d bytes, at most %d", ErrTooLarge, len(data), limits.MaxBytes)
+	}
+
+	contentType = http.DetectContentType(data)
+	if len(limits.AllowedTypes) > 0 && !slices.Contains(limits.AllowedTypes, contentType) {
+		return "", fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+	if limits.MaxDimension > 0 && (cfg.Width > limits.MaxDimension || cfg.Height > limits.MaxDimension) {
+		return "", fmt.Errorf("%w: %dx%d, at most %d per side", ErrDimensionsTooLarge, cfg.Width, cfg.Height, limits.MaxDimension)
+	}
+
+	return contentType, nil
+}
+
+// Normalize validates the image, applies its EXIF orientation and re-encodes it,
+// PNG stays PNG to keep the transparency, the other formats become JPEG
+func Normalize(data []byte, limits Limits) ([]byte, string, error) {
+	contentType, err := Validate(data, limits)
+	if err != nil {
+		return nil, "", err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("%w: %v", ErrUnsupportedFormat, err)
+	}
+
+	if contentType == MIMEJPEG {
+		img = Orient(img, exifOrientation(data))
+	}
+
+	format := FormatJPEG
+	if contentType == MIMEPNG {
+		format = FormatPNG
+	}
+	out, err := EncodeBytes(img, format, 92)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return out, format.ContentType(), nil
+}
+
+// Orient returns the image upright, orientation is the EXIF value from 1 to 8
+func Orient(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	src := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2:
+				dx, dy = w-1-x, y
+			case 3:
+				dx, dy = w-1-x, h-1-y
+			case 4:
+				dx, dy = x, h-1-y
+			case 5:
+				dx, dy = y, x
+			case 6:
+				dx, dy = h-1-y, x
+			case 7:
+				dx, dy = h-1-y, w-1-x
+			case 8:
+				dx, dy = y, w-1-x
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):dst.PixOffset(dx, dy)+4], src.Pix[src.PixOffset(x, y):src.PixOffset(x, y)+4])
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,292 @@
+package imaging
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+type TextEffect string
+
+const (
+	TextEffectNone    TextEffect = "none"
+	TextEffectOutline TextEffect = "outline"
+	TextEffectShadow  TextEffect = "shadow"
+)
+
+// TextStyle is how the overlay text is drawn
+type TextStyle struct {
+	Font   FontFamily `json:"font" bson:"font"`
+	Color  string     `json:"color" bson:"color"`
+	Effect TextEffect `json:"effect" bson:"effect"`
+}
+
+// Placement is where the overlay text goes, Vertical is top, center or bottom and Horizontal left, center or right
+type Placement struct {
+	Vertical   string `json:"vertical" bson:"vertical"`
+	Horizontal string `json:"horizontal" bson:"horizontal"`
+}
+
+var namedColors = map[string]color.NRGBA{
+	"white":  {R: 255, G: 255, B: 255, A: 255},
+	"black":  {A: 255},
+	"red":    {R: 220, G: 38, B: 38, A: 255},
+	"orange": {R: 249, G: 115, B: 22, A: 255},
+	"yellow": {R: 250, G: 204, B: 21, A: 255},
+	"gold":   {R: 212, G: 175, B: 55, A: 255},
+	"green":  {R: 22, G: 163, B: 74, A: 255},
+	"blue":   {R: 37, G: 99, B: 235, A: 255},
+	"navy":   {R: 30, G: 41, B: 89, A: 255},
+	"purple": {R: 147, G: 51, B: 234, A: 255},
+	"pink":   {R: 236, G: 72, B: 153, A: 255},
+	"brown":  {R: 120, G: 72, B: 40, A: 255},
+	"gray":   {R: 128, G: 128, B: 128, A: 255},
+	"grey":   {R: 128, G: 128, B: 128, A: 255},
+	"cream":  {R: 255, G: 248, B: 220, A: 255},
+}
+
+// ParseTextStyle maps a free text style, e.g. "bold retro red with outline", to a bundled font, a color and an effect.
+// Unknown words are ignored, the default is bold white text with an outline
+func ParseTextStyle(s string) TextStyle {
+	style := TextStyle{Font: FontBold, Color: "white", Effect: TextEffectOutline}
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r != '#' && r != '-' && (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	})
+
+	has := func(keywords ...string) bool {
+		for _, w := range words {
+			for _, k := range keywords {
+				if w == k {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	bold := has("bold", "heavy", "strong", "impact")
+	switch {
+	case has("mono", "monospace", "typewriter", "code", "tech", "pixel", "retro", "digital"):
+		style.Font = FontMono
+		if bold {
+			style.Font = FontMonoBold
+		}
+	case has("script", "cursive", "handwritten", "elegant", "italic", "calligraphy"):
+		style.Font = FontItalic
+		if bold {
+			style.Font = FontBoldItalic
+		}
+	case has("vintage", "classic", "smallcaps", "serif"):
+		style.Font = FontSmallCaps
+	case has("light", "thin", "regular", "minimal", "minimalist"):
+		style.Font = FontRegular
+	case has("medium"):
+		style.Font = FontMedium
+	}
+
+	switch {
+	case has("shadow", "3d"):
+		style.Effect = TextEffectShadow
+	case has("flat", "plain"):
+		style.Effect = TextEffectNone
+	}
+
+	for _, w := range words {
+		if _, ok := namedColors[w]; ok {
+			style.Color = w
+			break
+		}
+		if _, ok := parseHexColor(w); ok {
+			style.Color = w
+			break
+		}
+	}
+	return style
+}
+
+func (s TextStyle) color() color.NRGBA {
+	if c, ok := namedColors[s.Color]; ok {
+		return c
+	}
+	if c, ok := parseHexColor(s.Color); ok {
+		return c
+	}
+	return namedColors["white"]
+}
+
+func parseHexColor(s string) (color.NRGBA, bool) {
+	if len(s) != 7 || s[0] != '#' {
+		return color.NRGBA{}, false
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return color.NRGBA{}, false
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, true
+}
+
+// ParsePlacement maps a free layout, e.g. "text at the top left", to a placement, bottom center by default
+func ParsePlacement(s string) Placement {
+	var (
+		p        = Placement{Vertical: "bottom", Horizontal: "center"}
+		vertical string
+		centered bool
+	)
+	for _, w := range strings.Fields(strings.ToLower(s)) {
+		switch strings.Trim(w, ".,;:-") {
+		case "top", "above", "header":
+			vertical = "top"
+		case "bottom", "below", "footer":
+			vertical = "bottom"
+		case "middle", "center", "centered":
+			centered = true
+		case "left":
+			p.Horizontal = "left"
+		case "right":
+			p.Horizontal = "right"
+		}
+	}
+
+	// "top center" is a centered line at the top, center alone is the middle of the image
+	switch {
+	case vertical != "":
+		p.Vertical = vertical
+	case centered:
+		p.Vertical = "center"
+	}
+	return p
+}
+
+// DrawText returns a copy of the image with the text drawn in the style at the placement,
+// the text is wrapped and sized to fit a band of the image
+func DrawText(img image.Image, text string, style TextStyle, placement Placement) (image.Image, error) {
+	text = strings.Join(strings.Fields(text), " ")
+	if text == "" {
+		return img, nil
+	}
+
+	f, err := bundledFont(style.Font)
+	if err != nil {
+		return nil, err
+	}
+
+	dst := toNRGBA(img)
+	b := dst.Bounds()
+	var (
+		marginX = float64(b.Dx()) * 0.07
+		marginY = float64(b.Dy()) * 0.06
+		maxW    = float64(b.Dx()) - 2*marginX
+		maxH    = float64(b.Dy()) * 0.3
+	)
+
+	// the largest size at which the wrapped text fits the band
+	var (
+		face  font.Face
+		lines []string
+	)
+	for size := float64(b.Dy()) / 7; ; size *= 0.9 {
+		if size < 8 {
+			return nil, errors.New("text is too long to fit the image")
+		}
+		if face, err = newFace(f, size); err != nil {
+			return nil, err
+		}
+
+		var fits bool
+		lines, fits = wrapText(face, text, maxW)
+		lineH := float64(face.Metrics().Height.Ceil()) * 1.1
+		if fits && float64(len(lines))*lineH <= maxH {
+			break
+		}
+		_ = face.Close()
+	}
+	defer func() { _ = face.Close() }()
+
+	var (
+		metrics = face.Metrics()
+		lineH   = float64(metrics.Height.Ceil()) * 1.1
+		blockH  = float64(len(lines)) * lineH
+		top     = marginY
+	)
+	switch placement.Vertical {
+	case "center":
+		top = (float64(b.Dy()) - blockH) / 2
+	case "bottom":
+		top = float64(b.Dy()) - marginY - blockH
+	}
+
+	fill := style.color()
+	stroke := color.NRGBA{A: 255}
+	if luminance(fill) < 128 {
+		stroke = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	}
+	size := float64(metrics.Height.Ceil())
+
+	for i, line := range lines {
+		width := float64(font.MeasureString(face, line).Ceil())
+		x := marginX
+		switch placement.Horizontal {
+		case "center":
+			x = (float64(b.Dx()) - width) / 2
+		case "right":
+			x = float64(b.Dx()) - marginX - width
+		}
+		y := top + float64(i)*lineH + float64(metrics.Ascent.Ceil())
+
+		d := font.Drawer{Dst: dst, Face: face}
+		drawAt := func(c color.Color, dx, dy float64) {
+			d.Src = image.NewUniform(c)
+			d.Dot = fixed.P(int(math.Round(x+dx)), int(math.Round(y+dy)))
+			d.DrawString(line)
+		}
+
+		switch style.Effect {
+		case TextEffectOutline:
+			r := max(1, size/20)
+			for a := 0; a < 16; a++ {
+				angle := float64(a) * math.Pi / 8
+				drawAt(stroke, r*math.Cos(angle), r*math.Sin(angle))
+			}
+		case TextEffectShadow:
+			o := max(1, size/16)
+			drawAt(color.NRGBA{A: 170}, o, o)
+		}
+		drawAt(fill, 0, 0)
+	}
+	return dst, nil
+}
+
+// wrapText breaks the text into lines no wider than maxW, it does not fit when a single word is wider
+func wrapText(face font.Face, text string, maxW float64) ([]string, bool) {
+	var (
+		lines []string
+		line  string
+	)
+	for _, word := range strings.Fields(text) {
+		if float64(font.MeasureString(face, word).Ceil()) > maxW {
+			return nil, false
+		}
+
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if float64(font.MeasureString(face, candidate).Ceil()) <= maxW {
+			line = candidate
+			continue
+		}
+		lines = append(lines, line)
+		line = word
+	}
+	return append(lines, line), true
+}
+
+func luminance(c color.NRGBA) float64 {
+	return 0.299*float64(c.R) + 0.587*float64(c.G) + 0.114*float64(c.B)
+}
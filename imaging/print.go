@@ -0,0 +1,162 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"io"
+	"maps"
+	"math"
+	"slices"
+)
+
+type ColorIntent string
+
+const (
+	ColorIntentSRGB ColorIntent = "srgb"
+
+	// ColorIntentCMYK marks a file the printer converts to CMYK, PNG has no CMYK so the pixels stay sRGB
+	ColorIntentCMYK ColorIntent = "cmyk"
+)
+
+// ParseColorIntent parses the intent, srgb by default
+func ParseColorIntent(s string) (ColorIntent, error) {
+	switch ColorIntent(s) {
+	case "", ColorIntentSRGB:
+		return ColorIntentSRGB, nil
+	case ColorIntentCMYK:
+		return ColorIntentCMYK, nil
+	}
+	return "", errors.New("color intent must be srgb or cmyk")
+}
+
+// PrintSpec is the printable area of a product, the bleed is added on every side
+type PrintSpec struct {
+	WidthIn  float64 `json:"widthIn"`
+	HeightIn float64 `json:"heightIn"`
+	BleedIn  float64 `json:"bleedIn"`
+	DPI      int     `json:"dpi"`
+}
+
+// Size returns the size of the file in pixels, bleed included
+func (s PrintSpec) Size() (int, int) {
+	return int(math.Round((s.WidthIn + 2*s.BleedIn) * float64(s.DPI))),
+		int(math.Round((s.HeightIn + 2*s.BleedIn) * float64(s.DPI)))
+}
+
+// PrintReport tells how well the source fills the print, EffectiveDPI under the DPI of the spec means the source is upscaled
+type PrintReport struct {
+	WidthPx      int      `json:"widthPx" bson:"widthPx"`
+	HeightPx     int      `json:"heightPx" bson:"heightPx"`
+	SourceWidth  int      `json:"sourceWidth" bson:"sourceWidth"`
+	SourceHeight int      `json:"sourceHeight" bson:"sourceHeight"`
+	EffectiveDPI float64  `json:"effectiveDpi" bson:"effectiveDpi"`
+	Warnings     []string `json:"warnings" bson:"warnings"`
+}
+
+// Print crops the image to the aspect ratio of the spec, bleed included, and resizes it to the exact print size
+func Print(img image.Image, spec PrintSpec, crop CropMode) (image.Image, PrintReport) {
+	w, h := spec.Size()
+	cropped := Crop(img, Ratio{W: w, H: h}, crop)
+
+	b, cb := img.Bounds(), cropped.Bounds()
+	report := PrintReport{
+		WidthPx:      w,
+		HeightPx:     h,
+		SourceWidth:  b.Dx(),
+		SourceHeight: b.Dy(),
+		EffectiveDPI: math.Round(float64(cb.Dx())/(spec.WidthIn+2*spec.BleedIn)*10) / 10,
+		Warnings:     make([]string, 0),
+	}
+
+	switch {
+	case report.EffectiveDPI < float64(spec.DPI)/2:
+		report.Warnings = append(report.Warnings, fmt.Sprintf("source is too small: %.0f effective dpi, %d required, the print will look blurry", report.EffectiveDPI, spec.DPI))
+	case report.EffectiveDPI < float64(spec.DPI):
+		report.Warnings = append(report.Warnings, fmt.Sprintf("source is upscaled: %.0f effective dpi, %d required", report.EffectiveDPI, spec.DPI))
+	}
+	if lost := 1 - float64(cb.Dx()*cb.Dy())/float64(b.Dx()*b.Dy()); lost > 0.2 {
+		report.Warnings = append(report.Warnings, fmt.Sprintf("%.0f%% of the source is cropped to fit the product", lost*100))
+	}
+
+	return Resize(cropped, w, h), report
+}
+
+// EncodePrintPNG writes the image as PNG with its resolution (pHYs), the sRGB rendering intent and the text metadata
+func EncodePrintPNG(w io.Writer, img image.Image, dpi int, intent ColorIntent, text map[string]string) error {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+
+	// the chunks go right after IHDR, the signature is 8 bytes and IHDR 25
+	const ihdrEnd = 8 + 25
+	if len(data) < ihdrEnd || string(data[12:16]) != "IHDR" {
+		return errors.New("unexpected png encoding")
+	}
+
+	var chunks bytes.Buffer
+
+	// pixels per meter on both axes, unit 1 is the meter
+	ppm := uint32(math.Round(float64(dpi) / 0.0254))
+	phys := make([]byte, 9)
+	binary.BigEndian.PutUint32(phys[0:4], ppm)
+	binary.BigEndian.PutUint32(phys[4:8], ppm)
+	phys[8] = 1
+	writeChunk(&chunks, "pHYs", phys)
+
+	// 0 is the perceptual rendering intent, 1 relative colorimetric, which print shops expect for CMYK conversion
+	renderingIntent := byte(0)
+	if intent == ColorIntentCMYK {
+		renderingIntent = 1
+	}
+	writeChunk(&chunks, "sRGB", []byte{renderingIntent})
+
+	text = maps.Clone(text)
+	if text == nil {
+		text = make(map[string]string)
+	}
+	text["Color Intent"] = string(intent)
+	for _, key := range sortedKeys(text) {
+		writeChunk(&chunks, "tEXt", append(append([]byte(key), 0), text[key]...))
+	}
+
+	if _, err := w.Write(data[:ihdrEnd]); err != nil {
+		return err
+	}
+	if _, err := w.Write(chunks.Bytes()); err != nil {
+		return err
+	}
+	_, err := w.Write(data[ihdrEnd:])
+	return err
+}
+
+func writeChunk(w *bytes.Buffer, kind string, data []byte) {
+	var length [4]byte
+	binary.BigEndian.PutUint32(length[:], uint32(len(data)))
+	w.Write(length[:])
+
+	crc := crc32.NewIEEE()
+	crc.Write([]byte(kind))
+	crc.Write(data)
+	w.WriteString(kind)
+	w.Write(data)
+
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], crc.Sum32())
+	w.Write(sum[:])
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
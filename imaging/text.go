@@ -0,0 +1,112 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/gomedium"
+	"golang.org/x/image/font/gofont/gomono"
+	"golang.org/x/image/font/gofont/gomonobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/gofont/gosmallcaps"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+// FontFamily is one of the bundled fonts, the Go font family
+type FontFamily string
+
+const (
+	FontRegular    FontFamily = "regular"
+	FontMedium     FontFamily = "medium"
+	FontBold       FontFamily = "bold"
+	FontItalic     FontFamily = "italic"
+	FontBoldItalic FontFamily = "bold-italic"
+	FontMono       FontFamily = "mono"
+	FontMonoBold   FontFamily = "mono-bold"
+	FontSmallCaps  FontFamily = "small-caps"
+)
+
+var fontFiles = map[FontFamily][]byte{
+	FontRegular:    goregular.TTF,
+	FontMedium:     gomedium.TTF,
+	FontBold:       gobold.TTF,
+	FontItalic:     goitalic.TTF,
+	FontBoldItalic: gobolditalic.TTF,
+	FontMono:       gomono.TTF,
+	FontMonoBold:   gomonobold.TTF,
+	FontSmallCaps:  gosmallcaps.TTF,
+}
+
+// fonts caches the parsed fonts, by family
+var fonts sync.Map
+
+// bundledFont returns the parsed font of the family, bold when the family is unknown
+func bundledFont(family FontFamily) (*opentype.Font, error) {
+	if _, ok := fontFiles[family]; !ok {
+		family = FontBold
+	}
+	if f, ok := fonts.Load(family); ok {
+		return f.(*opentype.Font), nil
+	}
+
+	f, err := opentype.Parse(fontFiles[family])
+	if err != nil {
+		return nil, err
+	}
+	fonts.Store(family, f)
+	return f, nil
+}
+
+func newFace(f *opentype.Font, size float64) (font.Face, error) {
+	return opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+}
+
+// renderLabel draws a single line of text on a transparent image just large enough to hold it,
+// with a dark shadow so it reads on light and dark backgrounds
+func renderLabel(text string, f *opentype.Font, size float64, fg color.Color) (*image.NRGBA, error) {
+	face, err := newFace(f, size)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = face.Close() }()
+
+	var (
+		metrics = face.Metrics()
+		shadow  = max(1, int(size/24))
+		width   = font.MeasureString(face, text).Ceil() + shadow
+		height  = (metrics.Ascent + metrics.Descent).Ceil() + shadow
+	)
+
+	dst := image.NewNRGBA(image.Rect(0, 0, max(1, width), max(1, height)))
+	for _, layer := range []struct {
+		src    color.Color
+		offset int
+	}{
+		{src: color.NRGBA{A: 160}, offset: shadow},
+		{src: fg, offset: 0},
+	} {
+		d := font.Drawer{
+			Dst:  dst,
+			Src:  image.NewUniform(layer.src),
+			Face: face,
+			Dot:  fixed.P(layer.offset, metrics.Ascent.Ceil()+layer.offset),
+		}
+		d.DrawString(text)
+	}
+	return dst, nil
+}
+
+// toNRGBA copies the image into a new NRGBA image with its origin at 0, 0
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,187 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+)
+
+// Ratio is an aspect ratio, e.g. 4:5
+type Ratio struct {
+	W int
+	H int
+}
+
+func (r Ratio) IsZero() bool {
+	return r.W <= 0 || r.H <= 0
+}
+
+func (r Ratio) String() string {
+	if r.IsZero() {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", r.W, r.H)
+}
+
+// ParseRatio parses "4:5", an empty string is the zero ratio
+func ParseRatio(s string) (Ratio, error) {
+	if s == "" {
+		return Ratio{}, nil
+	}
+
+	w, h, ok := strings.Cut(s, ":")
+	if !ok {
+		return Ratio{}, errors.New("invalid ratio: " + s)
+	}
+	rw, errW := strconv.Atoi(w)
+	rh, errH := strconv.Atoi(h)
+	if errW != nil || errH != nil || rw <= 0 || rh <= 0 {
+		return Ratio{}, errors.New("invalid ratio: " + s)
+	}
+	return Ratio{W: rw, H: rh}, nil
+}
+
+type CropMode string
+
+const (
+	CropCenter CropMode = "center"
+
+	// CropSmart keeps the window with the most details, where the subject usually is
+	CropSmart CropMode = "smart"
+)
+
+// Resize scales the image to exactly w x h
+func Resize(img image.Image, w, h int) image.Image {
+	if w <= 0 || h <= 0 {
+		return img
+	}
+	b := img.Bounds()
+	if b.Dx() == w && b.Dy() == h {
+		return img
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// Fit scales the image down to fit in w x h keeping its aspect ratio, a zero side is not bounded.
+// Images are never scaled up
+func Fit(img image.Image, w, h int) image.Image {
+	b := img.Bounds()
+	if w <= 0 {
+		w = b.Dx()
+	}
+	if h <= 0 {
+		h = b.Dy()
+	}
+	if b.Dx() <= w && b.Dy() <= h {
+		return img
+	}
+
+	scale := min(float64(w)/float64(b.Dx()), float64(h)/float64(b.Dy()))
+	return Resize(img, max(1, int(float64(b.Dx())*scale+0.5)), max(1, int(float64(b.Dy())*scale+0.5)))
+}
+
+// Thumbnail fits the image in a size x size square
+func Thumbnail(img image.Image, size int) image.Image {
+	return Fit(img, size, size)
+}
+
+// Crop cuts the largest window of the ratio out of the image
+func Crop(img image.Image, ratio Ratio, mode CropMode) image.Image {
+	if ratio.IsZero() {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	cw, ch := w, w*ratio.H/ratio.W
+	if ch > h {
+		cw, ch = h*ratio.W/ratio.H, h
+	}
+	if cw == w && ch == h {
+		return img
+	}
+
+	var x, y int
+	if mode == CropSmart {
+		x, y = smartOffset(img, cw, ch)
+	} else {
+		x, y = (w-cw)/2, (h-ch)/2
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, cw, ch))
+	draw.Draw(dst, dst.Bounds(), img, b.Min.Add(image.Pt(x, y)), draw.Src)
+	return dst
+}
+
+// smartOffset slides the window along the long side and returns the offset with the most edge energy,
+// it works on a small copy of the image since only the rough position matters
+func smartOffset(img image.Image, cw, ch int) (int, int) {
+	const sample = 128
+
+	b := img.Bounds()
+	scale := min(1, float64(sample)/float64(max(b.Dx(), b.Dy())))
+	sw, sh := max(1, int(float64(b.Dx())*scale)), max(1, int(float64(b.Dy())*scale))
+	small := image.NewGray(image.Rect(0, 0, sw, sh))
+	draw.ApproxBiLinear.Scale(small, small.Bounds(), img, b, draw.Src, nil)
+
+	// energy of every column and row, the sum of the gradients of its pixels
+	cols, rows := make([]int, sw), make([]int, sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			v := int(small.GrayAt(x, y).Y)
+			var e int
+			if x+1 < sw {
+				e += abs(v - int(small.GrayAt(x+1, y).Y))
+			}
+			if y+1 < sh {
+				e += abs(v - int(small.GrayAt(x, y+1).Y))
+			}
+			cols[x] += e
+			rows[y] += e
+		}
+	}
+
+	if cw < b.Dx() {
+		x := bestWindow(cols, max(1, int(float64(cw)*scale)))
+		return min(int(float64(x)/scale), b.Dx()-cw), 0
+	}
+	y := bestWindow(rows, max(1, int(float64(ch)*scale)))
+	return 0, min(int(float64(y)/scale), b.Dy()-ch)
+}
+
+// bestWindow returns the start of the size long window with the highest sum,
+// ties go to the most centered one
+func bestWindow(energy []int, size int) int {
+	if size >= len(energy) {
+		return 0
+	}
+
+	var sum int
+	for _, e := range energy[:size] {
+		sum += e
+	}
+
+	best, bestSum := 0, sum
+	center := (len(energy) - size) / 2
+	for start := 1; start+size <= len(energy); start++ {
+		sum += energy[start+size-1] - energy[start-1]
+		if sum > bestSum || (sum == bestSum && abs(start-center) < abs(best-center)) {
+			best, bestSum = start, sum
+		}
+	}
+	return best
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
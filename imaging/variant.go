@@ -0,0 +1,157 @@
+package imaging
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// the variants are served to anonymous clients, every parameter comes from a short list
+// so that the number of variants of an image, and the rendering work, stays bounded
+var (
+	VariantSizes     = []int{128, 256, 512, 1024, 2048}
+	VariantQualities = []int{60, 75, 85, 95}
+	VariantRatios    = []Ratio{{W: 1, H: 1}, {W: 4, H: 5}, {W: 5, H: 4}, {W: 3, H: 4}, {W: 4, H: 3}, {W: 2, H: 3}, {W: 3, H: 2}, {W: 9, H: 16}, {W: 16, H: 9}}
+)
+
+// ThumbnailSize is the side of the square thumbnails
+const ThumbnailSize = 256
+
+// Variant describes a derived image: cropped to Ratio, then fit in Width x Height, then encoded in Format
+type Variant struct {
+	Width   int
+	Height  int
+	Ratio   Ratio
+	Crop    CropMode
+	Format  Format
+	Quality int
+}
+
+// ThumbnailVariant is the variant shown in the listings
+var ThumbnailVariant = Variant{Width: ThumbnailSize, Height: ThumbnailSize, Format: FormatWebP}
+
+// ParseVariant reads the variant of the query, "w", "h", "ratio", "crop", "fmt" and "q",
+// format is the format of the original, used when "fmt" is missing.
+// "q" only applies to jpeg, png and webp are lossless so a quality is rejected rather than ignored
+func ParseVariant(q url.Values, format Format) (Variant, error) {
+	v := Variant{Format: format, Crop: CropCenter}
+
+	var err error
+	if v.Width, err = parseDimension(q.Get("w")); err != nil {
+		return v, err
+	}
+	if v.Height, err = parseDimension(q.Get("h")); err != nil {
+		return v, err
+	}
+	if v.Ratio, err = ParseRatio(q.Get("ratio")); err != nil {
+		return v, err
+	}
+	if !v.Ratio.IsZero() && !slices.Contains(VariantRatios, v.Ratio) {
+		return v, fmt.Errorf("ratio must be one of %s", joinRatios(VariantRatios))
+	}
+	if s := q.Get("crop"); s != "" {
+		v.Crop = CropMode(s)
+		if v.Crop != CropCenter && v.Crop != CropSmart {
+			return v, errors.New("crop must be center or smart")
+		}
+	}
+	if s := q.Get("fmt"); s != "" {
+		if v.Format, err = ParseFormat(s); err != nil {
+			return v, err
+		}
+	}
+	if s := q.Get("q"); s != "" {
+		if v.Quality, err = strconv.Atoi(s); err != nil || !slices.Contains(VariantQualities, v.Quality) {
+			return v, fmt.Errorf("q must be one of %s", joinInts(VariantQualities))
+		}
+	}
+
+	if v.Format != FormatJPEG {
+		if v.Quality != 0 {
+			return v, fmt.Errorf("q only applies to jpeg, %s is lossless", v.Format)
+		}
+	} else if v.Quality == 0 {
+		v.Quality = DefaultQuality
+	}
+	return v, nil
+}
+
+func parseDimension(s string) (int, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := strconv.Atoi(s)
+	if err != nil || !slices.Contains(VariantSizes, d) {
+		return 0, fmt.Errorf("dimensions must be one of %s", joinInts(VariantSizes))
+	}
+	return d, nil
+}
+
+func joinInts(values []int) string {
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = strconv.Itoa(v)
+	}
+	return strings.Join(s, ", ")
+}
+
+func joinRatios(ratios []Ratio) string {
+	s := make([]string, len(ratios))
+	for i, r := range ratios {
+		s[i] = r.String()
+	}
+	return strings.Join(s, ", ")
+}
+
+// Key identifies the variant in the cache, two equal variants have the same key
+func (v Variant) Key() string {
+	parts := []string{fmt.Sprintf("w%d", v.Width), fmt.Sprintf("h%d", v.Height)}
+	if !v.Ratio.IsZero() {
+		parts = append(parts, fmt.Sprintf("r%dx%d", v.Ratio.W, v.Ratio.H), string(v.Crop))
+	}
+	if v.Quality > 0 {
+		parts = append(parts, fmt.Sprintf("q%d", v.Quality))
+	}
+	return strings.Join(parts, "-")
+}
+
+// Query is the inverse of ParseVariant
+func (v Variant) Query() url.Values {
+	q := url.Values{}
+	if v.Width > 0 {
+		q.Set("w", strconv.Itoa(v.Width))
+	}
+	if v.Height > 0 {
+		q.Set("h", strconv.Itoa(v.Height))
+	}
+	if !v.Ratio.IsZero() {
+		q.Set("ratio", v.Ratio.String())
+		q.Set("crop", string(v.Crop))
+	}
+	if v.Format != "" {
+		q.Set("fmt", string(v.Format))
+	}
+	if v.Quality > 0 {
+		q.Set("q", strconv.Itoa(v.Quality))
+	}
+	return q
+}
+
+// Apply crops and fits the image
+func (v Variant) Apply(img image.Image) image.Image {
+	img = Crop(img, v.Ratio, v.Crop)
+	return Fit(img, v.Width, v.Height)
+}
+
+// Render decodes the original, applies the variant and encodes the result
+func (v Variant) Render(original []byte) ([]byte, error) {
+	img, err := Decode(original)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeBytes(v.Apply(img), v.Format, v.Quality)
+}
@@ -0,0 +1,126 @@
+package imaging
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+type WatermarkMode string
+
+const (
+	// WatermarkTiled repeats the mark over the whole image, it is hard to crop out
+	WatermarkTiled WatermarkMode = "tiled"
+
+	// WatermarkCorner puts a single mark in the bottom right corner
+	WatermarkCorner WatermarkMode = "corner"
+)
+
+// Watermark marks the previews with a logo, or a text when there is no logo
+type Watermark struct {
+	mode    WatermarkMode
+	opacity float64
+	mark    *image.NRGBA
+	key     string
+}
+
+// NewWatermark prepares the mark, logo is an encoded image and takes precedence over text,
+// opacity goes from 0 to 1. With neither text nor logo the watermark is disabled
+func NewWatermark(text string, logo []byte, mode WatermarkMode, opacity float64) (Watermark, error) {
+	if mode == "" {
+		mode = WatermarkTiled
+	}
+	if mode != WatermarkTiled && mode != WatermarkCorner {
+		return Watermark{}, errors.New("watermark mode must be tiled or corner")
+	}
+	if opacity <= 0 || opacity > 1 {
+		opacity = 0.35
+	}
+
+	w := Watermark{mode: mode, opacity: opacity}
+	sum := sha256.New()
+	_, _ = fmt.Fprintf(sum, "%s|%.2f|", mode, opacity)
+
+	switch {
+	case len(logo) > 0:
+		img, err := Decode(logo)
+		if err != nil {
+			return Watermark{}, fmt.Errorf("invalid watermark logo: %w", err)
+		}
+		w.mark = toNRGBA(img)
+		sum.Write(logo)
+	case text != "":
+		f, err := bundledFont(FontBold)
+		if err != nil {
+			return Watermark{}, err
+		}
+		if w.mark, err = renderLabel(text, f, 96, color.White); err != nil {
+			return Watermark{}, err
+		}
+		sum.Write([]byte(text))
+	default:
+		return w, nil
+	}
+
+	w.key = "wm" + hex.EncodeToString(sum.Sum(nil))[:8]
+	return w, nil
+}
+
+// Enabled reports whether the watermark has a mark to draw
+func (w Watermark) Enabled() bool {
+	return w.mark != nil
+}
+
+// Key changes with the mark, the mode and the opacity, so that cached previews are rendered again
+func (w Watermark) Key() string {
+	return w.key
+}
+
+// Apply returns a copy of the image with the mark drawn over it
+func (w Watermark) Apply(img image.Image) image.Image {
+	if !w.Enabled() {
+		return img
+	}
+
+	dst := toNRGBA(img)
+	b := dst.Bounds()
+	mask := image.NewUniform(color.Alpha{A: uint8(w.opacity * 255)})
+
+	if w.mode == WatermarkCorner {
+		mark := w.scaled(b.Dx() / 4)
+		margin := b.Dx() / 40
+		r := mark.Bounds().Add(image.Pt(b.Dx()-mark.Bounds().Dx()-margin, b.Dy()-mark.Bounds().Dy()-margin))
+		draw.DrawMask(dst, r, mark, image.Point{}, mask, image.Point{}, draw.Over)
+		return dst
+	}
+
+	// tiles of a fifth of the width, every other row shifted by half a tile
+	mark := w.scaled(b.Dx() / 5)
+	var (
+		mw, mh = mark.Bounds().Dx(), mark.Bounds().Dy()
+		stepX  = mw * 3 / 2
+		stepY  = max(mh*3, mw/2)
+	)
+	for row, y := 0, stepY/3; y < b.Dy(); row, y = row+1, y+stepY {
+		x := -mw / 2
+		if row%2 == 1 {
+			x += stepX / 2
+		}
+		for ; x < b.Dx(); x += stepX {
+			r := mark.Bounds().Add(image.Pt(x, y))
+			draw.DrawMask(dst, r, mark, image.Point{}, mask, image.Point{}, draw.Over)
+		}
+	}
+	return dst
+}
+
+// scaled returns the mark resized to the width, keeping its aspect ratio
+func (w Watermark) scaled(width int) image.Image {
+	width = max(width, 16)
+	mb := w.mark.Bounds()
+	return Resize(w.mark, width, max(1, mb.Dy()*width/mb.Dx()))
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/httpclient"
+	"github.com/namhq1989/demo-ai/imaging"
+	"github.com/namhq1989/demo-ai/storage"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// extensions of the input image types the providers accept
+var mapImageContentTypeExt = map[string]string{
+	"image/jpeg": ".jpeg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+type uploadConfig struct {
+	Limits imaging.Limits
+
+	// Fetcher downloads the images given by url, it only dials public addresses
+	Fetcher *http.Client
+}
+
+// readFormImage reads the file uploaded in the field of a multipart request, nil when there is none
+func (a *app) readFormImage(c echo.Context, field string) ([]byte, error) {
+	if !strings.HasPrefix(c.Request().Header.Get(echo.HeaderContentType), echo.MIMEMultipartForm) {
+		return nil, nil
+	}
+
+	fh, err := c.FormFile(field)
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if a.uploads.Limits.MaxBytes > 0 && fh.Size > a.uploads.Limits.MaxBytes {
+		return nil, imaging.ErrTooLarge
+	}
+
+	file, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	return readLimited(file, a.uploads.Limits.MaxBytes)
+}
+
+// fetchImage downloads the image of the url, internal addresses are refused
+func (a *app) fetchImage(ctx context.Context, rawURL string) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("invalid imageUrl")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := a.uploads.Fetcher.Do(req)
+	if errors.Is(err, httpclient.ErrForbiddenAddress) {
+		return nil, errors.New("imageUrl points to a forbidden address")
+	}
+	if err != nil {
+		return nil, errors.New("cannot download imageUrl")
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot download imageUrl, status code: %d", resp.StatusCode)
+	}
+	if a.uploads.Limits.MaxBytes > 0 && resp.ContentLength > a.uploads.Limits.MaxBytes {
+		return nil, imaging.ErrTooLarge
+	}
+
+	return readLimited(resp.Body, a.uploads.Limits.MaxBytes)
+}
+
+// readLimited reads at most max bytes, a longer content is an error rather than truncated
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, imaging.ErrTooLarge
+	}
+	return b, nil
+}
+
+// decodeBase64Image accepts raw base64 or a data url, "data:image/png;base64,..."
+func decodeBase64Image(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "data:") {
+		if _, data, ok := strings.Cut(s, ";base64,"); ok {
+			s = data
+		}
+	}
+
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid base64 image")
+	}
+	return b, nil
+}
+
+// storeInputImage saves the input image of an edit, an image already stored is reused
+func (a *app) storeInputImage(ctx context.Context, data []byte) (database.ImageRef, error) {
+	contentType := http.DetectContentType(data)
+	ext, ok := mapImageContentTypeExt[contentType]
+	if !ok {
+		return database.ImageRef{}, errors.New("unsupported image type: " + contentType)
+	}
+
+	object, err := storage.SaveContent(data, ext)
+	if err != nil {
+		return database.ImageRef{}, err
+	}
+
+	var image database.Image
+	err = a.colImage.FindOneAndUpdate(ctx,
+		bson.M{"hash": object.Hash},
+		bson.M{"$setOnInsert": bson.M{
+			"_id":         database.NewObjectID(),
+			"name":        object.Name,
+			"url":         object.URL,
+			"size":        object.Size,
+			"contentType": contentType,
+			"createdAt":   time.Now(),
+		}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&image)
+
+	// two uploads of the same image may race on the unique index, the loser reads the winner's record
+	if mongo.IsDuplicateKeyError(err) {
+		err = a.colImage.FindOne(ctx, bson.M{"hash": object.Hash}).Decode(&image)
+	}
+	if err != nil {
+		return database.ImageRef{}, err
+	}
+	return image.Ref(), nil
+}
@@ -2,22 +2,25 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/rand"
 	"fmt"
 	"net/http"
-	"sync"
+	"os"
 	"time"
 
-	oai "github.com/sashabaranov/go-openai"
-
-	"github.com/namhq1989/demo-ai/prodia"
-
 	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/breaker"
 	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/httpclient"
+	"github.com/namhq1989/demo-ai/imaging"
 	"github.com/namhq1989/demo-ai/openai"
+	"github.com/namhq1989/demo-ai/prodia"
+	"github.com/namhq1989/demo-ai/ratelimit"
 	"github.com/namhq1989/demo-ai/stablediffusion"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	"github.com/namhq1989/demo-ai/storage"
+	"github.com/namhq1989/demo-ai/style"
+	"github.com/namhq1989/demo-ai/throttle"
+	"github.com/namhq1989/demo-ai/util"
 )
 
 func main() {
@@ -27,189 +30,164 @@ func main() {
 
 	mgClient := database.NewMongoClient(cfg.MongoURL)
 	db := mgClient.Database(cfg.MongoDBName)
+	if err := database.EnsureIndexes(context.Background(), db); err != nil {
+		panic(err)
+	}
 	oa := openai.NewOpenAIClient(cfg.OpenAIToken)
 	sd := stablediffusion.NewStableDiffusion(cfg.StableDiffusionAPIKey)
 	pd := prodia.NewProdia(cfg.ProdiaAPIKey)
 
-	colHistory := database.ColHistory(db)
-
-	e.GET("/text-to-image", func(c echo.Context) error {
-		var (
-			err        error
-			wg         sync.WaitGroup
-			sdImageURL = ""
-			oaImageURL = ""
-			pdImageURL = ""
-
-			description        = c.QueryParam("description")
-			style              = c.QueryParam("style")
-			colorScheme        = c.QueryParam("colorScheme")
-			text               = c.QueryParam("text")
-			textStyle          = c.QueryParam("textStyle")
-			layout             = c.QueryParam("layout")
-			theme              = c.QueryParam("theme")
-			additionalElements = c.QueryParam("additionalElements")
-			product            = c.QueryParam("product")
-			prompt             = oa.GeneratePrompt(description, style, colorScheme, text, textStyle, layout, theme, additionalElements)
-		)
-
-		fmt.Println("got prompt:", prompt)
-
-		wg.Add(2)
-
-		// STABLE DIFFUSION
-		go func() {
-			defer wg.Done()
-
-			payload := stablediffusion.TextToImagePayload{
-				Prompt:      prompt,
-				Model:       stablediffusion.ModelSD3Turbo,
-				AspectRatio: sd.GetAspectRatioFromProduct(product),
-			}
-
-			sdImageURL, err = sd.TextToImage(payload)
-
-			fmt.Println("*** DONE STABLE DIFFUSION ***", sdImageURL)
-
-			if err != nil {
-				fmt.Println("[STABLE DIFFUSION] error when generating image:", err.Error())
-				return
-			}
-
-			// persist to db
-			history := database.History{
-				ID:                 database.NewObjectID(),
-				Name:               sdImageURL,
-				Service:            "stable-diffusion",
-				Type:               "text-to-image",
-				AIModel:            payload.Model,
-				AIConfiguration:    "",
-				Prompt:             payload.Prompt,
-				Description:        description,
-				Style:              style,
-				ColorScheme:        colorScheme,
-				Text:               text,
-				TextStyle:          textStyle,
-				Layout:             layout,
-				Theme:              theme,
-				AdditionalElements: additionalElements,
-				Product:            product,
-				CreatedAt:          time.Now(),
-			}
-			if _, err = colHistory.InsertOne(context.Background(), history); err != nil {
-				fmt.Println("error when persisting history to db:", err.Error())
-			}
-		}()
-
-		// OPENAI
-		go func() {
-			defer wg.Done()
-
-			payload := openai.TextToImagePayload{
-				Prompt:         prompt,
-				Model:          oai.CreateImageModelDallE3,
-				NumOfImages:    1,
-				ResponseFormat: "b64_json",
-				Size:           openai.GetSize(product),
-				Style:          "vivid",
-			}
+	pollCfg := prodia.DefaultPollConfig()
+	if cfg.ProdiaPollMaxWaitSec > 0 {
+		pollCfg.MaxWait = time.Duration(cfg.ProdiaPollMaxWaitSec) * time.Second
+		pd = pd.WithPollConfig(pollCfg)
+	}
+	if cfg.ProdiaCatalogTTLSec > 0 {
+		pd = pd.WithCatalogTTL(time.Duration(cfg.ProdiaCatalogTTLSec) * time.Second)
+	}
+
+	historyRepo := database.NewMongoHistoryRepository(db)
+
+	pdJobs := newProdiaJobRunner(pd, db, cfg.ProdiaPersistJobs, pollCfg.MaxWait)
+
+	breakerCfg := breaker.DefaultConfig()
+	if cfg.BreakerSlowThresholdSec > 0 {
+		breakerCfg.SlowThreshold = time.Duration(cfg.BreakerSlowThresholdSec) * time.Second
+	}
+	breakers := breaker.NewRegistry(breakerCfg)
+	for _, provider := range []string{providerStableDiffusion, providerOpenAI, providerProdia} {
+		breakers.Get(provider)
+	}
+
+	styles := style.Default()
+	if cfg.StylePresetsFile != "" {
+		registry, err := style.Load(cfg.StylePresetsFile)
+		if err != nil {
+			panic(err)
+		}
+		styles = registry
+	}
 
-			oaImageURL, err = oa.TextToImage(payload)
+	throttles := make(map[string]*throttle.Throttle)
+	for provider, limit := range vendorLimits(cfg.VendorLimits) {
+		throttles[provider] = throttle.New(provider, limit)
+	}
 
-			fmt.Println("*** DONE OPENAI ***", oaImageURL)
+	storage.VariantCacheMaxBytes = int64(cfg.ImageVariantCacheMB) << 20
 
-			if err != nil {
-				fmt.Println("[OPENAI] error when generating image:", err.Error())
-				return
-			}
-
-			// persist to db
-			history := database.History{
-				ID:                 database.NewObjectID(),
-				Name:               oaImageURL,
-				Service:            "openai",
-				Type:               "text-to-image",
-				AIModel:            payload.Model,
-				AIConfiguration:    "",
-				Prompt:             payload.Prompt,
-				Description:        description,
-				Style:              style,
-				ColorScheme:        colorScheme,
-				Text:               text,
-				TextStyle:          textStyle,
-				Layout:             layout,
-				Theme:              theme,
-				AdditionalElements: additionalElements,
-				Product:            product,
-				CreatedAt:          time.Now(),
-			}
-			if _, err = colHistory.InsertOne(context.Background(), history); err != nil {
-				fmt.Println("error when persisting history to db:", err.Error())
-			}
-		}()
-
-		// PRODIA
-		go func() {
-			// defer wg.Done()
-
-			width, height := prodia.GetSize(product)
-
-			payload := prodia.TextToImagePayload{
-				Model:    prodia.GetModel(style),
-				Prompt:   prompt,
-				Steps:    50,
-				CFGScale: 12,
-				Sampler:  prodia.GetSampler(style),
-				Width:    width,
-				Height:   height,
-			}
+	uploadLimits := imaging.DefaultLimits()
+	uploadLimits.MaxBytes = int64(cfg.ImageMaxBytes)
+	uploadLimits.MaxDimension = cfg.ImageMaxDimension
 
-			pdImageURL, err = pd.TextToImage(payload)
+	var logo []byte
+	if cfg.WatermarkLogoFile != "" {
+		b, err := os.ReadFile(cfg.WatermarkLogoFile)
+		if err != nil {
+			panic(err)
+		}
+		logo = b
+	}
+	watermark, err := imaging.NewWatermark(cfg.WatermarkText, logo, imaging.WatermarkMode(cfg.WatermarkMode), cfg.WatermarkOpacity)
+	if err != nil {
+		panic(err)
+	}
+
+	// without a configured secret the signed urls do not survive a restart
+	if cfg.ImageURLSecret == "" {
+		secret := make([]byte, 32)
+		if _, err = rand.Read(secret); err != nil {
+			panic(err)
+		}
+		cfg.ImageURLSecret = string(secret)
+		fmt.Println("[IMAGE] missing IMAGE_URL_SECRET, signing the image urls with a random secret")
+	}
+	util.SetImageURLSecret(cfg.ImageURLSecret)
+
+	a := &app{
+		sd:          sd,
+		oa:          oa,
+		pd:          pd,
+		pdJobs:      pdJobs,
+		historyRepo: historyRepo,
+		colUser:     database.ColUser(db),
+		colAPIKey:   database.ColAPIKey(db),
+		colImage:    database.ColImage(db),
+		colHistory:  database.ColHistory(db),
+
+		colCreditAccount: database.ColCreditAccount(db),
+		colCreditEntry:   database.ColCreditEntry(db),
+
+		colFeedback:         database.ColFeedback(db),
+		colGenerationWinner: database.ColGenerationWinner(db),
+
+		breakers:  breakers,
+		failover:  cfg.ProviderFailover,
+		throttles: throttles,
+		styles:    styles,
+		uploads: uploadConfig{
+			Limits:  uploadLimits,
+			Fetcher: httpclient.NewSafe(20 * time.Second),
+		},
+		previews: previewConfig{
+			Watermark:   watermark,
+			DownloadTTL: time.Duration(cfg.ImageDownloadTTLSec) * time.Second,
+		},
+		budget: budgetConfig{
+			Daily:            cfg.BudgetDaily,
+			DailyPerUser:     cfg.BudgetDailyPerUser,
+			DailyPerProvider: cfg.BudgetDailyPerProvider,
+			Downgrade:        cfg.BudgetDowngrade,
+		},
+		auth: authConfig{
+			Enabled:     cfg.AuthEnabled,
+			AdminAPIKey: cfg.AdminAPIKey,
+			JWTSecret:   cfg.JWTSecret,
+		},
+		credit: creditConfig{
+			FreeCredits:    int64(cfg.FreeCredits),
+			FreeDallEDaily: int64(cfg.FreeDallEDaily),
+		},
+	}
+
+	a.resumeProdiaJobs(context.Background())
+
+	go a.sweepHistories(context.Background(),
+		time.Duration(cfg.HistoryRetentionHours)*time.Hour,
+		time.Duration(cfg.HistorySweepIntervalMins)*time.Minute,
+	)
+
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if cfg.RateLimitStore != "memory" {
+		store, err := ratelimit.NewMongoStore(context.Background(), database.ColRateLimit(db))
+		if err != nil {
+			panic(err)
+		}
+		a.rateLimitStore = ratelimit.NewFallbackStore(store, rateLimitStore)
+		rateLimitStore = a.rateLimitStore
+	}
 
-			fmt.Println("*** DONE PRODIA ***", pdImageURL)
+	var (
+		window    = time.Duration(cfg.RateLimitWindowSec) * time.Second
+		expensive = rateLimiter(ratelimit.New(rateLimitStore, "expensive", int64(cfg.RateLimitExpensive), window))
+		cheap     = rateLimiter(ratelimit.New(rateLimitStore, "cheap", int64(cfg.RateLimitCheap), window))
+	)
 
-			if err != nil {
-				fmt.Println("[PRODIA] error when generating image:", err.Error())
-				return
-			}
+	// a global limit per ip covers every route, the ones outside of the route classes included,
+	// it runs before authentication so the callers behind a shared ip share it, keep it generous
+	e.Use(rateLimiter(ratelimit.New(rateLimitStore, "global", int64(cfg.RateLimitGlobal), window)))
 
-			b, _ := json.Marshal(payload)
-
-			// persist to db
-			history := database.History{
-				ID:                 database.NewObjectID(),
-				Name:               pdImageURL,
-				Service:            "prodia",
-				Type:               "text-to-image",
-				AIModel:            payload.Model,
-				AIConfiguration:    string(b),
-				Prompt:             payload.Prompt,
-				Description:        description,
-				Style:              style,
-				ColorScheme:        colorScheme,
-				Text:               text,
-				TextStyle:          textStyle,
-				Layout:             layout,
-				Theme:              theme,
-				AdditionalElements: additionalElements,
-				Product:            product,
-				CreatedAt:          time.Now(),
-			}
-			if _, err = colHistory.InsertOne(context.Background(), history); err != nil {
-				fmt.Println("error when persisting history to db:", err.Error())
-			}
-		}()
+	e.GET("/img/:name", a.image, cheap)
+	e.GET("/health", a.health, cheap)
+	e.GET("/metrics", a.metrics, cheap)
 
-		wg.Wait()
+	api := e.Group("", a.authenticate)
 
-		return c.JSON(http.StatusOK, echo.Map{"images": []map[string]interface{}{
-			{"url": sdImageURL, "type": "Stable Diffusion"},
-			{"url": oaImageURL, "type": "DALL-E-3"},
-			{"url": pdImageURL, "type": "Prodia"},
-		}})
-	})
+	api.GET("/text-to-image", a.textToImage, expensive)
+	api.GET("/styles", a.styleList, cheap)
+	api.GET("/providers/prodia/models", a.prodiaModels, cheap)
 
-	e.GET("/sd/image-image/sd3turbo", func(c echo.Context) error {
+	// debug route, it calls Stability directly without credits, budget or history
+	api.GET("/sd/image-image/sd3turbo", func(c echo.Context) error {
 		var (
 			payload = stablediffusion.ImageToImagePayload{
 				Prompt: "a kid is playing with a golden cat --3338767994",
@@ -223,128 +201,35 @@ func main() {
 		}
 
 		return c.JSON(http.StatusOK, echo.Map{"image": result.Image})
-	})
-
-	e.POST("/edit-image", func(c echo.Context) error {
-		// parse payload into editImagePayload
-		var payload editImagePayload
-		if err := c.Bind(&payload); err != nil {
-			return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
-		}
-
-		var (
-			err error
-			wg  sync.WaitGroup
-
-			sdImageURL = ""
-			oaImageURL = ""
-			pdImageURL = ""
-		)
-
-		wg.Add(1)
-
-		// STABLE DIFFUSION
-		go func() {
-			defer wg.Done()
-
-			sdImageURL, err = sd.EditImage(payload.Image, payload.Prompt)
-
-			fmt.Println("*** DONE STABLE DIFFUSION ***", sdImageURL)
-
-			if err != nil {
-				fmt.Println("[STABLE DIFFUSION] error when editing image:", err.Error())
-				return
-			}
-
-			// persist to db
-			history := database.History{
-				ID:        database.NewObjectID(),
-				Name:      sdImageURL,
-				Service:   "stable-diffusion",
-				Type:      "edit-image",
-				Prompt:    payload.Prompt,
-				CreatedAt: time.Now(),
-			}
-			if _, err = colHistory.InsertOne(context.Background(), history); err != nil {
-				fmt.Println("error when persisting history to db:", err.Error())
-			}
-		}()
-
-		// OPENAI: skip because only v2 supported
-
-		// PRODIA
-		go func() {
-			// defer wg.Done()
-
-			data := prodia.EditImagePayload{
-				MaskBlur:            1,
-				InpaintingFullRes:   false,
-				InpaitingFill:       0,
-				InpantingMaskInvert: 0,
-				ImageData:           payload.Image,
-				// ImageURL:            "https://adeptdept.com/storage/2024/02/ai-image-prompting-101-subject-orientation-pancakes.webp",
-				Model:    prodia.GetModel(payload.Style),
-				Prompt:   payload.Prompt,
-				Steps:    50,
-				CFGScale: 12,
-				Sampler:  prodia.GetSampler(payload.Style),
-				Seed:     0,
-			}
-
-			pdImageURL, err = pd.EditImage(data)
-
-			fmt.Println("*** DONE PRODIA ***", pdImageURL)
-
-			if err != nil {
-				fmt.Println("[PRODIA] error when editing image:", err.Error())
-				return
-			}
-
-			b, _ := json.Marshal(payload)
-
-			// persist to db
-			history := database.History{
-				ID:              database.NewObjectID(),
-				Name:            pdImageURL,
-				Service:         "prodia",
-				Type:            "edit-image",
-				AIModel:         data.Model,
-				AIConfiguration: string(b),
-				Prompt:          payload.Prompt,
-				CreatedAt:       time.Now(),
-			}
-			if _, err = colHistory.InsertOne(context.Background(), history); err != nil {
-				fmt.Println("error when persisting history to db:", err.Error())
-			}
-		}()
-
-		wg.Wait()
-
-		return c.JSON(http.StatusOK, echo.Map{"images": []map[string]interface{}{
-			{"url": sdImageURL, "type": "Stable Diffusion"},
-			{"url": oaImageURL, "type": "DALL-E-3"},
-			{"url": pdImageURL, "type": "Prodia"},
-		}})
-	})
-
-	e.GET("/histories", func(c echo.Context) error {
-		var limit int64 = 50
-		histories := make([]database.History, 0)
-		cursor, err := colHistory.Find(context.Background(), bson.D{}, &options.FindOptions{Sort: bson.M{"_id": -1}, Limit: &limit})
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
-		}
-		if err = cursor.All(context.Background(), &histories); err != nil {
-			return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
-		}
-		return c.JSON(http.StatusOK, echo.Map{"histories": histories})
-	})
+	}, expensive, a.requireAdmin)
+
+	api.POST("/edit-image", a.editImage, expensive)
+
+	api.GET("/histories", a.histories, cheap)
+	api.GET("/histories/:id", a.history, cheap)
+	api.DELETE("/histories/:id", a.deleteHistory, cheap)
+	api.POST("/histories/:id/restore", a.restoreHistory, cheap)
+	api.POST("/histories/:id/variations", a.variations, expensive)
+	api.GET("/histories/:id/lineage", a.lineage, cheap)
+	api.GET("/histories/:id/download", a.download, cheap)
+	api.POST("/histories/:id/approve", a.approveHistory, cheap, a.requireAdmin)
+	api.POST("/histories/:id/exports", a.exportHistory, expensive)
+	api.PUT("/histories/:id/feedback", a.giveFeedback, cheap)
+	api.POST("/generations/:id/winner", a.pickWinner, cheap)
+	api.GET("/feedback/stats", a.feedbackStats, cheap, a.requireAdmin)
+
+	api.GET("/costs", a.costs, cheap, a.requireAdmin)
+
+	api.GET("/credits", a.credits, cheap)
+	api.GET("/credits/entries", a.creditEntries, cheap)
+
+	admin := api.Group("/admin", cheap, a.requireAdmin)
+	admin.POST("/users", a.createUser)
+	admin.GET("/users", a.users)
+	admin.POST("/users/:id/keys", a.issueAPIKey)
+	admin.GET("/users/:id/keys", a.apiKeys)
+	admin.DELETE("/keys/:id", a.revokeAPIKey)
+	admin.POST("/users/:id/credits", a.topUpCredits)
 
 	e.Logger.Fatal(e.Start(":5000"))
 }
-
-type editImagePayload struct {
-	Image  string `json:"image"`
-	Prompt string `json:"prompt"`
-	Style  string `json:"style"`
-}
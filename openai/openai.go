@@ -2,7 +2,9 @@ package openai
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/namhq1989/demo-ai/httpclient"
 	oai "github.com/sashabaranov/go-openai"
 )
 
@@ -11,7 +13,14 @@ type OpenAI struct {
 }
 
 func NewOpenAIClient(token string) *OpenAI {
+	// DALL-E 3 may take a while to render an image
+	httpCfg := httpclient.DefaultConfig()
+	httpCfg.Timeout = 2 * time.Minute
+
+	cfg := oai.DefaultConfig(token)
+	cfg.HTTPClient = httpclient.New(httpCfg)
+
 	fmt.Printf("⚡️ [openai]: connected \n")
 
-	return &OpenAI{client: oai.NewClient(token)}
+	return &OpenAI{client: oai.NewClientWithConfig(cfg)}
 }
@@ -2,14 +2,12 @@ package openai
 
 import (
 	"context"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"math/rand"
-	"os"
 	"time"
 
-	"github.com/namhq1989/demo-ai/util"
+	"github.com/namhq1989/demo-ai/storage"
 	oai "github.com/sashabaranov/go-openai"
 )
 
@@ -20,50 +18,45 @@ type TextToImagePayload struct {
 	ResponseFormat string `json:"response_format"`
 	Size           string `json:"size"`
 	Style          string `json:"style"`
+	Quality        string `json:"quality"`
 }
 
-func (o OpenAI) TextToImage(payload TextToImagePayload) (string, error) {
+// TextToImage returns the urls of the generated images, DALL-E 3 only supports 1 image per request
+func (o OpenAI) TextToImage(payload TextToImagePayload) ([]string, error) {
 	resp, err := o.client.CreateImage(context.Background(), oai.ImageRequest{
 		Prompt:         payload.Prompt,
 		Model:          payload.Model,
 		N:              payload.NumOfImages,
 		Size:           payload.Size,
 		Style:          payload.Style,
+		Quality:        payload.Quality,
 		ResponseFormat: payload.ResponseFormat,
 	})
 
 	if err != nil {
-		return "", errors.New("cannot call openai api")
+		return nil, errors.New("cannot call openai api")
 	}
 
-	fileNames := make([]string, 0)
+	urls := make([]string, 0, len(resp.Data))
 
 	for _, item := range resp.Data {
 		// random seed
-		randSource := rand.New(rand.NewSource(time.Now().Unix()))
-		seed := randSource.Intn(4294967294)
+		seed := rand.Intn(4294967294)
 
 		fileName := fmt.Sprintf("%d-%d.jpeg", seed, time.Now().Unix())
-		filePath := fmt.Sprintf("generated/%s", fileName)
-		err = decodeAndSaveImage(item.B64JSON, filePath)
+		image, err := storage.SaveBase64(fileName, item.B64JSON)
 		if err != nil {
-			return "", fmt.Errorf("failed to decode and save image: %v", err)
+			return nil, fmt.Errorf("failed to decode and save image: %v", err)
 		}
 
-		fileNames = append(fileNames, fileName)
+		urls = append(urls, image.URL)
 	}
 
-	return util.GetImageURL(fileNames[0]), nil
-}
-
-func decodeAndSaveImage(base64Image, filePath string) error {
-	rawDecodedText, _ := base64.StdEncoding.DecodeString(base64Image)
-	err := os.WriteFile(filePath, rawDecodedText, 0644) // Use appropriate file permissions
-	if err != nil {
-		return err
+	if len(urls) == 0 {
+		return nil, errors.New("openai returned no image")
 	}
 
-	return nil
+	return urls, nil
 }
 
 var mapProductSize = map[string]string{
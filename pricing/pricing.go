@@ -0,0 +1,78 @@
+package pricing
+
+// Price is the estimated cost in USD of a single call
+type Price struct {
+	Provider string  `json:"provider"`
+	Model    string  `json:"model"`
+	Size     string  `json:"size"`
+	Cost     float64 `json:"cost"`
+}
+
+// Any matches every model or size of the provider
+const Any = "*"
+
+// prices are taken from the public pricing pages of the vendors,
+// Stability bills 1 credit = $0.01
+var prices = []Price{
+	// OpenAI, gpt-3.5-turbo is an average prompt generation call
+	{Provider: "openai", Model: "gpt-3.5-turbo", Size: Any, Cost: 0.0005},
+	{Provider: "openai", Model: "dall-e-3", Size: "1024x1024", Cost: 0.04},
+	{Provider: "openai", Model: "dall-e-3", Size: "1024x1792", Cost: 0.08},
+	{Provider: "openai", Model: "dall-e-3", Size: "1792x1024", Cost: 0.08},
+
+	// hd quality is priced as its own model
+	{Provider: "openai", Model: "dall-e-3-hd", Size: "1024x1024", Cost: 0.08},
+	{Provider: "openai", Model: "dall-e-3-hd", Size: "1024x1792", Cost: 0.12},
+	{Provider: "openai", Model: "dall-e-3-hd", Size: "1792x1024", Cost: 0.12},
+
+	// Stability
+	{Provider: "stable-diffusion", Model: "sd3", Size: Any, Cost: 0.065},
+	{Provider: "stable-diffusion", Model: "sd3-turbo", Size: Any, Cost: 0.04},
+	{Provider: "stable-diffusion", Model: "core", Size: Any, Cost: 0.03},
+	{Provider: "stable-diffusion", Model: "ultra", Size: Any, Cost: 0.08},
+	{Provider: "stable-diffusion", Model: "inpaint", Size: Any, Cost: 0.03},
+
+	// Prodia bills the same for every SDXL checkpoint
+	{Provider: "prodia", Model: Any, Size: Any, Cost: 0.0025},
+}
+
+// Estimate returns the cost of a call, exact matches win over Any, unknown models cost 0
+func Estimate(provider, model, size string) float64 {
+	var (
+		cost  float64
+		score = -1
+	)
+	for _, p := range prices {
+		if p.Provider != provider {
+			continue
+		}
+
+		s := 0
+		switch p.Model {
+		case model:
+			s += 2
+		case Any:
+		default:
+			continue
+		}
+		switch p.Size {
+		case size:
+			s++
+		case Any:
+		default:
+			continue
+		}
+
+		if s > score {
+			cost, score = p.Cost, s
+		}
+	}
+	return cost
+}
+
+// Table returns the full pricing table
+func Table() []Price {
+	table := make([]Price, len(prices))
+	copy(table, prices)
+	return table
+}
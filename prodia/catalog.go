@@ -0,0 +1,141 @@
+package prodia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	DefaultCatalogTTL = time.Hour
+
+	// fallbackTTL is how long the hardcoded catalog is used before Prodia API is asked again
+	fallbackTTL = time.Minute
+)
+
+// fallback catalog, used while Prodia API cannot be reached
+var (
+	DefaultModels   = []string{ModelAnimagineXLV3, ModelDreamshaperXL, ModelDynavisionXL, ModelRealismEngine, ModelRealvisXL}
+	DefaultSamplers = []string{
+		SamplerDPMPPSDEKarras, SamplerDPMPPSDEExponential, SamplerEuler, SamplerEulerA, SamplerLMS, SamplerHeun, SamplerDPM2,
+		SamplerDPM2a, SamplerDPMPP2MSDEHeunKarras, SamplerLMSKarras, SamplerDPM2Karras, SamplerDDIM, SamplerUniPC,
+	}
+)
+
+// Catalog lists the SDXL models and samplers available on Prodia
+type Catalog struct {
+	Models    []string  `json:"models"`
+	Samplers  []string  `json:"samplers"`
+	FetchedAt time.Time `json:"fetchedAt"`
+
+	// Fallback is true when the lists are the hardcoded defaults
+	Fallback bool `json:"fallback"`
+}
+
+type catalogCache struct {
+	ttl time.Duration
+
+	// refresh shares one fetch between the callers, it runs outside mu
+	refresh singleflight.Group
+
+	mu        sync.Mutex
+	catalog   Catalog
+	expiresAt time.Time
+}
+
+// WithCatalogTTL returns a copy of the client which caches the catalog for ttl
+func (p Prodia) WithCatalogTTL(ttl time.Duration) Prodia {
+	p.catalog = &catalogCache{ttl: ttl}
+	return p
+}
+
+// Catalog returns the cached catalog, it is fetched again once the TTL passed.
+// The expired catalog is served while it is fetched, only the first call waits for Prodia API.
+// When Prodia API fails the previous catalog is kept, or the defaults are used if there is none
+func (p Prodia) Catalog(ctx context.Context) Catalog {
+	cache := p.catalog
+
+	cache.mu.Lock()
+	catalog, expiresAt := cache.catalog, cache.expiresAt
+	cache.mu.Unlock()
+
+	if time.Now().Before(expiresAt) {
+		return catalog
+	}
+
+	// the catalog is shared, a caller giving up must not leave the others with the defaults
+	ch := cache.refresh.DoChan("catalog", func() (interface{}, error) {
+		return p.refreshCatalog(context.WithoutCancel(ctx)), nil
+	})
+	if !catalog.FetchedAt.IsZero() {
+		return catalog
+	}
+
+	res := <-ch
+	return res.Val.(Catalog)
+}
+
+// refreshCatalog fetches the catalog and stores it in the cache
+func (p Prodia) refreshCatalog(ctx context.Context) Catalog {
+	cache := p.catalog
+
+	catalog, err := p.fetchCatalog(ctx)
+
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	now := time.Now()
+	switch {
+	case err == nil:
+		cache.catalog, cache.expiresAt = catalog, now.Add(cache.ttl)
+	case cache.catalog.FetchedAt.IsZero() || cache.catalog.Fallback:
+		fmt.Println("[PRODIA] error when fetching catalog, using defaults:", err.Error())
+		cache.catalog = Catalog{Models: DefaultModels, Samplers: DefaultSamplers, FetchedAt: now, Fallback: true}
+		cache.expiresAt = now.Add(fallbackTTL)
+	default:
+		fmt.Println("[PRODIA] error when fetching catalog, keeping the previous one:", err.Error())
+		cache.expiresAt = now.Add(fallbackTTL)
+	}
+
+	return cache.catalog
+}
+
+func (p Prodia) fetchCatalog(ctx context.Context) (Catalog, error) {
+	catalog := Catalog{FetchedAt: time.Now()}
+
+	for url, list := range map[string]*[]string{
+		"https://api.prodia.com/v1/sdxl/models":   &catalog.Models,
+		"https://api.prodia.com/v1/sdxl/samplers": &catalog.Samplers,
+	} {
+		body, err := p.do(ctx, "GET", url, nil)
+		if err != nil {
+			return catalog, err
+		}
+		if err = json.Unmarshal(body, list); err != nil {
+			return catalog, fmt.Errorf("failed to unmarshal catalog: %v", err)
+		}
+		if len(*list) == 0 {
+			return catalog, fmt.Errorf("empty catalog: %s", url)
+		}
+	}
+
+	return catalog, nil
+}
+
+// Validate checks that the model and the sampler are available on Prodia
+func (p Prodia) Validate(ctx context.Context, model, sampler string) error {
+	catalog := p.Catalog(ctx)
+
+	if !slices.Contains(catalog.Models, model) {
+		return fmt.Errorf("unknown Prodia model: %s", model)
+	}
+	if !slices.Contains(catalog.Samplers, sampler) {
+		return fmt.Errorf("unknown Prodia sampler: %s", sampler)
+	}
+	return nil
+}
@@ -1,13 +1,11 @@
 package prodia
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"math/rand"
-	"net/http"
-	"strings"
-	"time"
 )
 
 type EditImagePayload struct {
@@ -18,33 +16,41 @@ type EditImagePayload struct {
 	ImageData           string `json:"imageData"`
 	Model               string `json:"model"`
 	Prompt              string `json:"prompt"`
+	NegativePrompt      string `json:"negative_prompt,omitempty"`
 	Steps               int    `json:"steps"`
 	CFGScale            int    `json:"cfg_scale"`
 	Sampler             string `json:"sampler"`
 	Seed                int    `json:"seed"`
 }
 
-func (p Prodia) EditImage(payload EditImagePayload) (string, error) {
-	// random seed
-	randSource := rand.New(rand.NewSource(time.Now().Unix()))
-	payload.Seed = randSource.Intn(4294967294)
-
-	// url := "https://api.prodia.com/v1/sdxl/inpainting"
-	url := "https://api.prodia.com/v1/sdxl/transform"
+func (p Prodia) EditImage(ctx context.Context, payload EditImagePayload) (string, error) {
+	jobID, err := p.SubmitEditImage(ctx, payload)
+	if err != nil {
+		return "", err
+	}
 
-	b, _ := json.Marshal(payload)
-	params := strings.NewReader(string(b))
+	return p.WaitForJob(ctx, jobID)
+}
 
-	req, _ := http.NewRequest("POST", url, params)
+// SubmitEditImage queues the transformation and returns the Prodia job id
+func (p Prodia) SubmitEditImage(ctx context.Context, payload EditImagePayload) (string, error) {
+	// random seed, unless the caller picked one
+	if payload.Seed == 0 {
+		payload.Seed = rand.Intn(4294967294)
+	}
 
-	req.Header.Add("accept", "application/json")
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("X-Prodia-Key", p.apiKey)
+	// url := "https://api.prodia.com/v1/sdxl/inpainting"
+	url := "https://api.prodia.com/v1/sdxl/transform"
 
-	res, _ := http.DefaultClient.Do(req)
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
 
-	defer func() { _ = res.Body.Close() }()
-	body, _ := io.ReadAll(res.Body)
+	body, err := p.do(ctx, "POST", url, bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
 
 	// map the body into apiTextToImageResponse
 	var response apiTextToImageResponse
@@ -54,14 +60,9 @@ func (p Prodia) EditImage(payload EditImagePayload) (string, error) {
 		return "", err
 	}
 
-	if response.Status != "queued" {
+	if response.Status != JobStatusQueued {
 		return "", fmt.Errorf("failed to generate Prodia image: %s", response.Status)
 	}
 
-	image, err := p.fetchJobData(response.Job, 0)
-	if err != nil {
-		return "", err
-	}
-
-	return fmt.Sprintf("http://localhost:5000/img/%s", image), nil
+	return response.Job, nil
 }
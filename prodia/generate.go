@@ -1,28 +1,29 @@
 package prodia
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
-	"os"
-	"strings"
 	"time"
 
-	"github.com/namhq1989/demo-ai/util"
+	"github.com/namhq1989/demo-ai/httpclient"
+	"github.com/namhq1989/demo-ai/storage"
 )
 
 type TextToImagePayload struct {
-	Model    string `json:"model"`
-	Prompt   string `json:"prompt"`
-	Steps    int    `json:"steps"`
-	CFGScale int    `json:"cfg_scale"`
-	Sampler  string `json:"sampler"`
-	Seed     int    `json:"seed"`
-	Width    int    `json:"width"`
-	Height   int    `json:"height"`
+	Model          string `json:"model"`
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negative_prompt,omitempty"`
+	Steps          int    `json:"steps"`
+	CFGScale       int    `json:"cfg_scale"`
+	Sampler        string `json:"sampler"`
+	Seed           int    `json:"seed"`
+	Width          int    `json:"width"`
+	Height         int    `json:"height"`
 }
 
 type apiTextToImageResponse struct {
@@ -30,111 +31,74 @@ type apiTextToImageResponse struct {
 	Status string `json:"status"`
 }
 
-func (p Prodia) TextToImage(payload TextToImagePayload) (string, error) {
-	// random seed
-	randSource := rand.New(rand.NewSource(time.Now().Unix()))
-	payload.Seed = randSource.Intn(4294967294)
-
-	url := "https://api.prodia.com/v1/sdxl/generate"
-
-	b, _ := json.Marshal(payload)
-	params := strings.NewReader(string(b))
-
-	req, _ := http.NewRequest("POST", url, params)
-
-	req.Header.Add("accept", "application/json")
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("X-Prodia-Key", p.apiKey)
-
-	res, _ := http.DefaultClient.Do(req)
-
-	defer func() { _ = res.Body.Close() }()
-	body, _ := io.ReadAll(res.Body)
-
-	// map the body into apiTextToImageResponse
-	var response apiTextToImageResponse
-	_ = json.Unmarshal(body, &response)
-
-	if response.Status != "queued" {
-		return "", fmt.Errorf("failed to generate Prodia image: %s", response.Status)
-	}
-
-	image, err := p.fetchJobData(response.Job, 0)
+func (p Prodia) TextToImage(ctx context.Context, payload TextToImagePayload) (string, error) {
+	jobID, err := p.SubmitTextToImage(ctx, payload)
 	if err != nil {
 		return "", err
 	}
 
-	return util.GetImageURL(image), nil
-}
-
-type apiFetchJobDataResponse struct {
-	Job      string `json:"job"`
-	Status   string `json:"status"`
-	ImageUrl string `json:"imageUrl"`
+	return p.WaitForJob(ctx, jobID)
 }
 
-func (p Prodia) fetchJobData(jobID string, attempts int) (string, error) {
-	if attempts > 10 {
-		return "", errors.New("cannot fetch Prodia job data")
+// SubmitTextToImage queues the generation and returns the Prodia job id
+func (p Prodia) SubmitTextToImage(ctx context.Context, payload TextToImagePayload) (string, error) {
+	// random seed, unless the caller picked one
+	if payload.Seed == 0 {
+		payload.Seed = rand.Intn(4294967294)
 	}
 
-	url := fmt.Sprintf("https://api.prodia.com/v1/job/%s", jobID)
-	req, _ := http.NewRequest("GET", url, nil)
-	req.Header.Add("accept", "application/json")
-	req.Header.Add("X-Prodia-Key", p.apiKey)
-
-	res, _ := http.DefaultClient.Do(req)
-
-	defer res.Body.Close()
-	body, _ := io.ReadAll(res.Body)
-
-	// map the body into apiTextToImageResponse
-	var response apiFetchJobDataResponse
-	_ = json.Unmarshal(body, &response)
-
-	if response.Status != "succeeded" {
-		// util.PrettyPrint(response)
+	url := "https://api.prodia.com/v1/sdxl/generate"
 
-		// fmt.Printf("job %s still running: %s \n", jobID, response.Status)
-		time.Sleep(5 * time.Second)
-		return p.fetchJobData(jobID, attempts+1)
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
 	}
 
-	name, err := p.downloadImage(response.ImageUrl)
+	body, err := p.do(ctx, "POST", url, bytes.NewReader(b))
 	if err != nil {
 		return "", err
 	}
 
-	return name, nil
-}
+	// map the body into apiTextToImageResponse
+	var response apiTextToImageResponse
+	if err = json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("failed to unmarshal response: %v", err)
+	}
 
-func (p Prodia) downloadImage(url string) (string, error) {
-	// random seed
-	randSource := rand.New(rand.NewSource(time.Now().Unix()))
-	seed := randSource.Intn(4294967294)
+	if response.Status != JobStatusQueued {
+		return "", fmt.Errorf("failed to generate Prodia image: %s", response.Status)
+	}
 
-	fileName := fmt.Sprintf("%d-%d.jpeg", seed, time.Now().Unix())
-	filePath := fmt.Sprintf("generated/%s", fileName)
+	return response.Job, nil
+}
 
-	// Create the output file
-	out, err := os.Create(filePath)
+func (p Prodia) downloadImage(ctx context.Context, jobID, url string) (string, error) {
+	// Download the image
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
-	defer out.Close()
-
-	// Download the image
-	resp, err := http.Get(url)
+	resp, err := p.client.Do(req)
 	if err != nil {
 		return "", err
 	}
 	defer resp.Body.Close()
 
-	// Copy the response body to the file
-	_, err = io.Copy(out, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return "", &httpclient.StatusError{StatusCode: resp.StatusCode}
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	// the job id keeps the name unique when several jobs finish at the same second
+	fileName := fmt.Sprintf("%s-%d.jpeg", jobID, time.Now().Unix())
+	image, err := storage.Save(fileName, data)
 	if err != nil {
 		return "", err
 	}
 
-	return fileName, nil
+	return image.URL, nil
 }
@@ -0,0 +1,117 @@
+package prodia
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	JobStatusQueued     = "queued"
+	JobStatusGenerating = "generating"
+	JobStatusSucceeded  = "succeeded"
+	JobStatusFailed     = "failed"
+	JobStatusCanceled   = "canceled"
+)
+
+type PollConfig struct {
+	// InitialDelay is the wait before the first poll, it grows by Multiplier until MaxDelay
+	InitialDelay time.Duration
+	MaxDelay     time.Duration
+	Multiplier   float64
+
+	// MaxWait is the total time a job is polled before giving up
+	MaxWait time.Duration
+}
+
+func DefaultPollConfig() PollConfig {
+	return PollConfig{
+		InitialDelay: 2 * time.Second,
+		MaxDelay:     10 * time.Second,
+		Multiplier:   1.5,
+		MaxWait:      2 * time.Minute,
+	}
+}
+
+var ErrJobTimeout = errors.New("timed out waiting for Prodia job")
+
+// JobError is returned when Prodia reports the job as failed or canceled
+type JobError struct {
+	JobID  string
+	Status string
+	Body   string
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("Prodia job %s %s: %s", e.JobID, e.Status, e.Body)
+}
+
+type apiFetchJobDataResponse struct {
+	Job      string `json:"job"`
+	Status   string `json:"status"`
+	ImageUrl string `json:"imageUrl"`
+}
+
+// WaitForJob polls the job until it reaches a terminal state, downloads the image and returns its url
+func (p Prodia) WaitForJob(ctx context.Context, jobID string) (string, error) {
+	cfg := p.pollCfg
+
+	if cfg.MaxWait > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.MaxWait)
+		defer cancel()
+	}
+
+	delay := cfg.InitialDelay
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", fmt.Errorf("%w %s", ErrJobTimeout, jobID)
+			}
+			return "", ctx.Err()
+		case <-timer.C:
+		}
+
+		job, err := p.fetchJobData(ctx, jobID)
+		if err != nil {
+			return "", err
+		}
+
+		switch job.Status {
+		case JobStatusSucceeded:
+			return p.downloadImage(ctx, jobID, job.ImageUrl)
+		case JobStatusFailed, JobStatusCanceled:
+			return "", &JobError{JobID: jobID, Status: job.Status, Body: job.raw}
+		}
+
+		delay = time.Duration(float64(delay) * cfg.Multiplier)
+		if delay > cfg.MaxDelay {
+			delay = cfg.MaxDelay
+		}
+	}
+}
+
+type jobData struct {
+	apiFetchJobDataResponse
+	raw string
+}
+
+func (p Prodia) fetchJobData(ctx context.Context, jobID string) (*jobData, error) {
+	url := fmt.Sprintf("https://api.prodia.com/v1/job/%s", jobID)
+	body, err := p.do(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var response apiFetchJobDataResponse
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal Prodia job %s: %v", jobID, err)
+	}
+
+	return &jobData{apiFetchJobDataResponse: response, raw: string(body)}, nil
+}
@@ -2,6 +2,7 @@ package prodia
 
 import "fmt"
 
+// models of the bundled style presets, the models available on Prodia are listed by Catalog
 const (
 	ModelAnimagineXLV3 = "animagineXLV3_v30.safetensors [75f2f05b]"
 	ModelDreamshaperXL = "dreamshaperXL10_alpha2.safetensors [c8afe2ef]"
@@ -10,38 +11,6 @@ const (
 	ModelRealvisXL     = "realvisxlV40.safetensors [f7fdcb51]"
 )
 
-var mapStyleModel = map[string]string{
-	"realistic":     ModelRealismEngine,
-	"cartoon":       ModelAnimagineXLV3,
-	"chibi":         ModelAnimagineXLV3,
-	"abstract":      ModelDreamshaperXL,
-	"minimalist":    ModelDreamshaperXL,
-	"vintage":       ModelRealvisXL,
-	"fantasy":       ModelAnimagineXLV3,
-	"surreal":       ModelDreamshaperXL,
-	"pop-art":       ModelAnimagineXLV3,
-	"watercolor":    ModelDreamshaperXL,
-	"pixel-art":     ModelAnimagineXLV3,
-	"line-art":      ModelDreamshaperXL,
-	"cyberpunk":     ModelAnimagineXLV3,
-	"steampunk":     ModelAnimagineXLV3,
-	"art-deco":      ModelDreamshaperXL,
-	"gothic":        ModelDreamshaperXL,
-	"impressionist": ModelDreamshaperXL,
-	"expressionist": ModelDreamshaperXL,
-	"sci-fi":        ModelAnimagineXLV3,
-	"3d-render":     ModelDynavisionXL,
-	"retro":         ModelRealvisXL,
-}
-
-func GetModel(style string) string {
-	model, exists := mapStyleModel[style]
-	if !exists {
-		return ModelDreamshaperXL
-	}
-	return model
-}
-
 var mapProductSize = map[string]string{
 	"t-shirt":    "819x1024",
 	"tumbler":    "1024x1024",
@@ -1,11 +1,57 @@
 package prodia
 
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/namhq1989/demo-ai/httpclient"
+)
+
 type Prodia struct {
-	apiKey string
+	apiKey  string
+	client  *http.Client
+	pollCfg PollConfig
+	catalog *catalogCache
 }
 
 func NewProdia(apiKey string) Prodia {
+	cfg := httpclient.DefaultConfig()
+	cfg.Timeout = 30 * time.Second
+
 	return Prodia{
-		apiKey: apiKey,
+		apiKey:  apiKey,
+		client:  httpclient.New(cfg),
+		pollCfg: DefaultPollConfig(),
+		catalog: &catalogCache{ttl: DefaultCatalogTTL},
 	}
 }
+
+// WithPollConfig returns a copy of the client which polls jobs with the given config
+func (p Prodia) WithPollConfig(cfg PollConfig) Prodia {
+	p.pollCfg = cfg
+	return p
+}
+
+// do sends a request to Prodia API and returns the response body
+func (p Prodia) do(ctx context.Context, method, url string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, fmt.Errorf("error creating request: %v", err)
+	}
+
+	req.Header.Add("accept", "application/json")
+	if body != nil {
+		req.Header.Add("content-type", "application/json")
+	}
+	req.Header.Add("X-Prodia-Key", p.apiKey)
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+
+	return httpclient.ReadBody(res)
+}
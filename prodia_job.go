@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/prodia"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errProdiaJobPending is returned when a persisted job is still running after the wait, it is resumed later
+var errProdiaJobPending = errors.New("prodia job is still running")
+
+// prodiaJobRunner waits for queued Prodia jobs,
+// when persistence is enabled the pending jobs survive a server restart
+type prodiaJobRunner struct {
+	pd      prodia.Prodia
+	colJob  *mongo.Collection
+	persist bool
+
+	// maxWait bounds the wait of a job, it is not tied to the request which submitted the job
+	maxWait time.Duration
+}
+
+func newProdiaJobRunner(pd prodia.Prodia, db *mongo.Database, persist bool, maxWait time.Duration) prodiaJobRunner {
+	return prodiaJobRunner{
+		pd:      pd,
+		colJob:  database.ColProdiaJob(db),
+		persist: persist,
+		maxWait: maxWait,
+	}
+}
+
+// wait returns the image url of the job, the job is persisted first so it can be resumed later.
+// The job is billed once submitted, so the wait goes on when the caller leaves
+func (r prodiaJobRunner) wait(ctx context.Context, job database.ProdiaJob) (string, error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), r.maxWait)
+	defer cancel()
+
+	if r.persist {
+		job.CreatedAt = time.Now()
+		if _, err := r.colJob.InsertOne(ctx, job); err != nil {
+			fmt.Println("[PRODIA] error when persisting pending job:", err.Error())
+		}
+	}
+
+	return r.await(ctx, job.ID, true)
+}
+
+// await waits for the job and removes it once done, an interrupted wait keeps the job so it is resumed on the next start.
+// keepOnTimeout keeps it on a timeout as well, a resumed job which times out is abandoned
+func (r prodiaJobRunner) await(ctx context.Context, jobID string, keepOnTimeout bool) (string, error) {
+	url, err := r.pd.WaitForJob(ctx, jobID)
+
+	keep := errors.Is(err, context.Canceled) || (keepOnTimeout && errors.Is(err, prodia.ErrJobTimeout))
+	if r.persist && !keep {
+		if _, delErr := r.colJob.DeleteOne(context.Background(), bson.M{"_id": jobID}); delErr != nil {
+			fmt.Println("[PRODIA] error when removing pending job:", delErr.Error())
+		}
+	}
+
+	return url, err
+}
+
+// pending returns the jobs left pending by the previous run
+func (r prodiaJobRunner) pending(ctx context.Context) ([]database.ProdiaJob, error) {
+	jobs := make([]database.ProdiaJob, 0)
+	cursor, err := r.colJob.Find(ctx, bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.All(ctx, &jobs); err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// completeProdiaJob waits for the job and fills the image of its history.
+// A persisted job still running after the wait is errProdiaJobPending, it is resumed in the background
+// and its credits are kept until it is done
+func (a *app) completeProdiaJob(ctx context.Context, job database.ProdiaJob) (database.History, error) {
+	url, err := a.pdJobs.wait(ctx, job)
+	if errors.Is(err, prodia.ErrJobTimeout) && a.pdJobs.persist {
+		go a.resumeProdiaJob(context.Background(), job)
+		return job.History, fmt.Errorf("%w: %s", errProdiaJobPending, job.ID)
+	}
+	if err != nil {
+		return database.History{}, err
+	}
+
+	history := job.History
+	history.Name = url
+	history.CreatedAt = time.Now()
+	return history, nil
+}
+
+// resumeProdiaJobs picks up the jobs left pending by the previous run
+func (a *app) resumeProdiaJobs(ctx context.Context) {
+	if !a.pdJobs.persist {
+		return
+	}
+
+	jobs, err := a.pdJobs.pending(ctx)
+	if err != nil {
+		fmt.Println("[PRODIA] error when loading pending jobs:", err.Error())
+		return
+	}
+
+	for _, job := range jobs {
+		go a.resumeProdiaJob(ctx, job)
+	}
+}
+
+// resumeProdiaJob waits once more for a pending job and finishes its history like a generation,
+// a job which fails or times out again is abandoned and its credits are refunded
+func (a *app) resumeProdiaJob(ctx context.Context, job database.ProdiaJob) {
+	fmt.Println("[PRODIA] resuming job", job.ID)
+
+	ctx, cancel := context.WithTimeout(ctx, a.pdJobs.maxWait)
+	defer cancel()
+
+	url, err := a.pdJobs.await(ctx, job.ID, false)
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err != nil {
+		fmt.Println("[PRODIA] error when resuming job, abandoning it:", err.Error())
+		a.refundCredits(context.Background(), job.History.UserID, job.History.Type, []string{providerProdia}, job.History.GenerationID, job.Credits)
+		return
+	}
+
+	history := job.History
+	history.Name = url
+	history.CreatedAt = time.Now()
+	a.finishHistory(history, job.Overlay)
+}
@@ -0,0 +1,44 @@
+package product
+
+import (
+	"sort"
+
+	"github.com/namhq1989/demo-ai/imaging"
+)
+
+// Spec describes the printable area of a product, the print size follows the aspect ratio
+type Spec struct {
+	Name  string            `json:"name"`
+	Ratio imaging.Ratio     `json:"-"`
+	Print imaging.PrintSpec `json:"print"`
+}
+
+// apparel is printed without bleed, the large prints at 150 dpi which is enough at viewing distance
+var specs = map[string]Spec{
+	"t-shirt":    {Name: "t-shirt", Ratio: imaging.Ratio{W: 4, H: 5}, Print: imaging.PrintSpec{WidthIn: 12, HeightIn: 15, DPI: 300}},
+	"tumbler":    {Name: "tumbler", Ratio: imaging.Ratio{W: 1, H: 1}, Print: imaging.PrintSpec{WidthIn: 8, HeightIn: 8, BleedIn: 0.125, DPI: 300}},
+	"phone-case": {Name: "phone-case", Ratio: imaging.Ratio{W: 9, H: 16}, Print: imaging.PrintSpec{WidthIn: 3.375, HeightIn: 6, BleedIn: 0.125, DPI: 300}},
+	"hoodie":     {Name: "hoodie", Ratio: imaging.Ratio{W: 4, H: 5}, Print: imaging.PrintSpec{WidthIn: 12, HeightIn: 15, DPI: 300}},
+	"mug":        {Name: "mug", Ratio: imaging.Ratio{W: 1, H: 1}, Print: imaging.PrintSpec{WidthIn: 4, HeightIn: 4, BleedIn: 0.125, DPI: 300}},
+	"tote-bag":   {Name: "tote-bag", Ratio: imaging.Ratio{W: 4, H: 5}, Print: imaging.PrintSpec{WidthIn: 12, HeightIn: 15, DPI: 300}},
+	"pillow":     {Name: "pillow", Ratio: imaging.Ratio{W: 1, H: 1}, Print: imaging.PrintSpec{WidthIn: 18, HeightIn: 18, BleedIn: 0.5, DPI: 150}},
+	"poster":     {Name: "poster", Ratio: imaging.Ratio{W: 2, H: 3}, Print: imaging.PrintSpec{WidthIn: 18, HeightIn: 27, BleedIn: 0.125, DPI: 150}},
+	"notebook":   {Name: "notebook", Ratio: imaging.Ratio{W: 3, H: 2}, Print: imaging.PrintSpec{WidthIn: 9, HeightIn: 6, BleedIn: 0.125, DPI: 300}},
+	"sticker":    {Name: "sticker", Ratio: imaging.Ratio{W: 1, H: 1}, Print: imaging.PrintSpec{WidthIn: 3, HeightIn: 3, BleedIn: 0.0625, DPI: 300}},
+}
+
+// Get returns the spec of the product
+func Get(name string) (Spec, bool) {
+	spec, ok := specs[name]
+	return spec, ok
+}
+
+// List returns the specs sorted by name
+func List() []Spec {
+	list := make([]Spec, 0, len(specs))
+	for _, spec := range specs {
+		list = append(list, spec)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/namhq1989/demo-ai/breaker"
+	"github.com/namhq1989/demo-ai/throttle"
+)
+
+var providerLabels = map[string]string{
+	providerStableDiffusion: "Stable Diffusion",
+	providerOpenAI:          "DALL-E-3",
+	providerProdia:          "Prodia",
+}
+
+var (
+	textToImageProviders = []string{providerStableDiffusion, providerOpenAI, providerProdia}
+
+	// OpenAI is skipped because only DALL-E 2 supports editing
+	editImageProviders = []string{providerStableDiffusion, providerProdia}
+)
+
+// providers used when the request and the style preset do not specify any
+var mapProductProviders = map[string][]string{
+	"sticker": {providerProdia, providerOpenAI},
+}
+
+// resolveProviders returns the providers requested by the client,
+// or the defaults of the style preset, then of the product, which are supported by the operation
+func resolveProviders(requested, styleProviders []string, product string, supported []string) ([]string, error) {
+	providers := make([]string, 0, len(supported))
+	for _, p := range requested {
+		p = strings.TrimSpace(p)
+		if p == "" || containsProvider(providers, p) {
+			continue
+		}
+		if !containsProvider(supported, p) {
+			return nil, fmt.Errorf("unsupported provider: %s", p)
+		}
+		providers = append(providers, p)
+	}
+	if len(providers) > 0 {
+		return providers, nil
+	}
+
+	defaults := styleProviders
+	if len(defaults) == 0 {
+		defaults = mapProductProviders[product]
+	}
+	for _, p := range defaults {
+		if containsProvider(supported, p) {
+			providers = append(providers, p)
+		}
+	}
+	if len(providers) > 0 {
+		return providers, nil
+	}
+
+	return supported, nil
+}
+
+// splitProviders parses "prodia,openai"
+func splitProviders(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func containsProvider(providers []string, provider string) bool {
+	for _, p := range providers {
+		if p == provider {
+			return true
+		}
+	}
+	return false
+}
+
+// imageResult is an item of the "images" response
+type imageResult struct {
+	HistoryID string `json:"historyId,omitempty"`
+	URL       string `json:"url"`
+	Type      string `json:"type"`
+	Provider  string `json:"provider"`
+
+	// QueueDepth is the number of calls ahead in the vendor queue, QueueWaitMs the time spent waiting for a slot
+	QueueDepth  int   `json:"queueDepth"`
+	QueueWaitMs int64 `json:"queueWaitMs"`
+
+	// Pending is true when the image is still generated in the background, its history is created once it is ready
+	Pending bool `json:"pending,omitempty"`
+}
+
+func newImageResult(provider string, ticket throttle.Ticket) imageResult {
+	return imageResult{
+		Type:        providerLabels[provider],
+		Provider:    provider,
+		QueueDepth:  ticket.Depth,
+		QueueWaitMs: ticket.Wait.Milliseconds(),
+	}
+}
+
+// callProvider runs fn once the vendor has a free slot, guarded by the breaker of the provider.
+// wait, when not nil, runs after fn once the slot is released, for the vendors which queue the work as a job.
+// The breaker records the outcome and the duration of both.
+// An open breaker fails fast, so the caller does not wait in the queue for nothing
+func (a *app) callProvider(ctx context.Context, provider string, fn, wait func() error) (throttle.Ticket, error) {
+	b := a.breakers.Get(provider)
+	if !b.Allowed() {
+		return throttle.Ticket{}, breaker.ErrOpen
+	}
+
+	release := func() {}
+	var ticket throttle.Ticket
+	if t, ok := a.throttles[provider]; ok {
+		r, tk, err := t.Acquire(ctx)
+		if err != nil {
+			return tk, err
+		}
+		release, ticket = r, tk
+	}
+
+	done, err := b.Start()
+	if err != nil {
+		release()
+		return ticket, err
+	}
+
+	err = fn()
+	release()
+
+	if err == nil && wait != nil {
+		err = wait()
+	}
+	done(err)
+
+	return ticket, err
+}
+
+// defaultVendorLimits follow the default rate limits of the vendor accounts
+var defaultVendorLimits = map[string]throttle.Config{
+	providerStableDiffusion: {MaxConcurrent: 4, RPM: 600, MaxQueue: 50},
+	providerOpenAI:          {MaxConcurrent: 2, RPM: 7, MaxQueue: 20},
+	providerProdia:          {MaxConcurrent: 5, RPM: 60, MaxQueue: 50},
+}
+
+// vendorLimits returns the default limits overridden by "provider=concurrency:rpm" entries
+func vendorLimits(overrides map[string]string) map[string]throttle.Config {
+	limits := make(map[string]throttle.Config, len(defaultVendorLimits))
+	for provider, cfg := range defaultVendorLimits {
+		limits[provider] = cfg
+	}
+
+	for provider, s := range overrides {
+		cfg, ok := limits[provider]
+		if !ok {
+			cfg = throttle.Config{MaxQueue: 50}
+		}
+		if _, err := fmt.Sscanf(s, "%d:%d", &cfg.MaxConcurrent, &cfg.RPM); err != nil {
+			fmt.Printf("invalid vendor limit %s=%s \n", provider, s)
+			continue
+		}
+		limits[provider] = cfg
+	}
+	return limits
+}
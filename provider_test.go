@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/namhq1989/demo-ai/breaker"
+	"github.com/namhq1989/demo-ai/prodia"
+	"github.com/namhq1989/demo-ai/throttle"
+)
+
+func TestCallProviderOpensTheBreakerWhenTheJobsFail(t *testing.T) {
+	cfg := breaker.DefaultConfig()
+	cfg.MinRequests = 3
+
+	a := &app{
+		breakers:  breaker.NewRegistry(cfg),
+		throttles: map[string]*throttle.Throttle{providerProdia: throttle.New(providerProdia, throttle.Config{MaxConcurrent: 1})},
+	}
+	ctx := context.Background()
+
+	submit := func() error { return nil }
+	wait := func() error {
+		// the slot is free while the job runs
+		waitCtx, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		release, _, err := a.throttles[providerProdia].Acquire(waitCtx)
+		if err != nil {
+			t.Fatalf("slot is held during the wait: %v", err)
+		}
+		release()
+
+		return &prodia.JobError{JobID: "job", Status: prodia.JobStatusFailed}
+	}
+
+	for i := 0; i < cfg.MinRequests; i++ {
+		if _, err := a.callProvider(ctx, providerProdia, submit, wait); !errors.As(err, new(*prodia.JobError)) {
+			t.Fatalf("call %d: err = %v, want the job error", i, err)
+		}
+	}
+
+	if state := a.breakers.Get(providerProdia).Status().State; state != breaker.StateOpen {
+		t.Fatalf("state = %s, want %s", state, breaker.StateOpen)
+	}
+	if _, err := a.callProvider(ctx, providerProdia, submit, wait); !errors.Is(err, breaker.ErrOpen) {
+		t.Fatalf("err = %v, want %v", err, breaker.ErrOpen)
+	}
+}
@@ -0,0 +1,37 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// FallbackStore counts in the primary store and, while it fails, in the fallback store,
+// so an outage of the shared store degrades the limits to per replica instead of removing them
+type FallbackStore struct {
+	primary  Store
+	fallback Store
+	failures atomic.Int64
+}
+
+func NewFallbackStore(primary, fallback Store) *FallbackStore {
+	return &FallbackStore{primary: primary, fallback: fallback}
+}
+
+func (s *FallbackStore) Hit(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	count, resetAt, err := s.primary.Hit(ctx, key, window)
+	if err == nil {
+		return count, resetAt, nil
+	}
+
+	if s.failures.Add(1)%100 == 1 {
+		fmt.Println("[RATE LIMIT] error when hitting the store, counting in the fallback:", err.Error())
+	}
+	return s.fallback.Hit(ctx, key, window)
+}
+
+// Failures is the number of hits the primary store failed
+func (s *FallbackStore) Failures() int64 {
+	return s.failures.Load()
+}
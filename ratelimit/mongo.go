@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore shares the counters between replicas, a TTL index removes the expired windows
+type MongoStore struct {
+	col *mongo.Collection
+}
+
+type mongoCounter struct {
+	Count    int64     `bson:"count"`
+	ExpireAt time.Time `bson:"expireAt"`
+}
+
+func NewMongoStore(ctx context.Context, col *mongo.Collection) (*MongoStore, error) {
+	_, err := col.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expireAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rate limit index: %v", err)
+	}
+
+	return &MongoStore{col: col}, nil
+}
+
+func (s *MongoStore) Hit(ctx context.Context, key string, window time.Duration) (int64, time.Time, error) {
+	start := windowStart(time.Now(), window)
+	resetAt := start.Add(window)
+
+	var counter mongoCounter
+	err := s.col.FindOneAndUpdate(ctx,
+		bson.M{"_id": fmt.Sprintf("%s:%d", key, start.Unix())},
+		bson.M{"$inc": bson.M{"count": 1}, "$setOnInsert": bson.M{"expireAt": resetAt}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return counter.Count, resetAt, nil
+}
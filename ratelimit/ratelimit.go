@@ -0,0 +1,61 @@
+package ratelimit
+
+import (
+	"context"
+	"time"
+)
+
+// Store counts the hits of a key in fixed windows, it must be safe for concurrent use
+type Store interface {
+	// Hit adds a hit to the current window of the key and returns the hits of the window and its end
+	Hit(ctx context.Context, key string, window time.Duration) (int64, time.Time, error)
+}
+
+type Limiter struct {
+	store  Store
+	name   string
+	limit  int64
+	window time.Duration
+}
+
+// Result is the state of the key after a hit
+type Result struct {
+	Allowed   bool
+	Limit     int64
+	Remaining int64
+	ResetAt   time.Time
+}
+
+// New returns a limiter which allows limit hits per window, name separates the counters of the limiters sharing a store
+func New(store Store, name string, limit int64, window time.Duration) *Limiter {
+	return &Limiter{
+		store:  store,
+		name:   name,
+		limit:  limit,
+		window: window,
+	}
+}
+
+func (l *Limiter) Allow(ctx context.Context, id string) (Result, error) {
+	count, resetAt, err := l.store.Hit(ctx, l.name+":"+id, l.window)
+	if err != nil {
+		return Result{}, err
+	}
+
+	remaining := l.limit - count
+	if remaining < 0 {
+		remaining = 0
+	}
+
+	return Result{
+		Allowed:   count <= l.limit,
+		Limit:     l.limit,
+		Remaining: remaining,
+		ResetAt:   resetAt,
+	}, nil
+}
+
+// windowStart aligns t on the window, so every replica uses the same bucket
+func windowStart(t time.Time, window time.Duration) time.Time {
+	return t.Truncate(window)
+}
@@ -1,20 +1,23 @@
 package main
 
 import (
+	"fmt"
+	"math"
 	"net/http"
 	"os"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/namhq1989/demo-ai/ratelimit"
 )
 
 func initRest() *echo.Echo {
 	// echo instance
 	e := echo.New()
 
-	e.Static("/img", "generated")
-
 	// middlewares
 	setMiddleware(e)
 
@@ -23,7 +26,6 @@ func initRest() *echo.Echo {
 
 func setMiddleware(e *echo.Echo) {
 	addCorsMiddleware(e)
-	addRateLimiter(e)
 	e.Use(middleware.GzipWithConfig(middleware.GzipConfig{
 		Level: 5,
 	}))
@@ -46,28 +48,48 @@ func addCorsMiddleware(e *echo.Echo) {
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     allowedOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"},
-		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type"},
+		AllowHeaders:     []string{"Accept", "Authorization", "Content-Type", "X-API-Key"},
+		ExposeHeaders:    []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           300,
 	}))
 }
 
-func addRateLimiter(e *echo.Echo) {
-	cfg := middleware.RateLimiterConfig{
-		Skipper: middleware.DefaultSkipper,
-		Store: middleware.NewRateLimiterMemoryStoreWithConfig(
-			middleware.RateLimiterMemoryStoreConfig{Rate: 60, Burst: 60, ExpiresIn: 5 * time.Minute},
-		),
-		IdentifierExtractor: func(ctx echo.Context) (string, error) {
-			id := ctx.RealIP()
-			return id, nil
-		},
-		ErrorHandler: func(context echo.Context, err error) error {
-			return context.JSON(http.StatusForbidden, nil)
-		},
-		DenyHandler: func(context echo.Context, identifier string, err error) error {
-			return context.JSON(http.StatusTooManyRequests, nil)
-		},
+// rateLimitFailOpen counts the requests let through because the limit could not be checked
+var rateLimitFailOpen atomic.Int64
+
+// rateLimiter limits the requests of the caller, anonymous callers are identified by ip
+func rateLimiter(l *ratelimit.Limiter) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			id := getCaller(c).ID
+			if id == "" {
+				id = c.RealIP()
+			}
+
+			res, err := l.Allow(c.Request().Context(), id)
+			if err != nil {
+				// do not block the traffic when the store is down
+				rateLimitFailOpen.Add(1)
+				fmt.Println("[RATE LIMIT] error when checking limit, letting the request through:", err.Error())
+				return next(c)
+			}
+
+			h := c.Response().Header()
+			h.Set("X-RateLimit-Limit", strconv.FormatInt(res.Limit, 10))
+			h.Set("X-RateLimit-Remaining", strconv.FormatInt(res.Remaining, 10))
+			h.Set("X-RateLimit-Reset", strconv.FormatInt(res.ResetAt.Unix(), 10))
+
+			if !res.Allowed {
+				retryAfter := int64(math.Ceil(time.Until(res.ResetAt).Seconds()))
+				if retryAfter < 1 {
+					retryAfter = 1
+				}
+				h.Set("Retry-After", strconv.FormatInt(retryAfter, 10))
+				return c.JSON(http.StatusTooManyRequests, echo.Map{"message": "too many requests", "retryAfter": retryAfter})
+			}
+
+			return next(c)
+		}
 	}
-	e.Use(middleware.RateLimiterWithConfig(cfg))
 }
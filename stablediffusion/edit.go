@@ -10,6 +10,9 @@ import (
 	"mime/multipart"
 	"net/http"
 	"time"
+
+	"github.com/namhq1989/demo-ai/httpclient"
+	"github.com/namhq1989/demo-ai/storage"
 )
 
 func (sd StableDiffusion) EditImage(imgBase64, prompt string) (string, error) {
@@ -79,21 +82,15 @@ func (sd StableDiffusion) callEditImageAPIAndProcessResponse(requestBody *bytes.
 	req.Header.Set("accept", "application/json")
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := sd.client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("error sending request: %v", err)
 	}
-	defer resp.Body.Close()
 
 	// read the response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := httpclient.ReadBody(resp)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("non-200 status code: %d, body: %s", resp.StatusCode, string(body))
+		return "", err
 	}
 
 	// parse the response
@@ -107,11 +104,10 @@ func (sd StableDiffusion) callEditImageAPIAndProcessResponse(requestBody *bytes.
 	randSource := rand.New(rand.NewSource(time.Now().Unix()))
 	seed := randSource.Intn(4294967294)
 	fileName := fmt.Sprintf("%d-%d.jpeg", seed, time.Now().Unix())
-	filePath := fmt.Sprintf("generated/%s", fileName)
-	err = decodeAndSaveImage(responsePayload.Image, filePath)
+	image, err := storage.SaveBase64(fileName, responsePayload.Image)
 	if err != nil {
 		return "", fmt.Errorf("failed to decode and save image: %v", err)
 	}
 
-	return fmt.Sprintf("http://localhost:5000/img/%s", fileName), nil
+	return image.URL, nil
 }
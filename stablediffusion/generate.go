@@ -2,7 +2,6 @@ package stablediffusion
 
 import (
 	"bytes"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,18 +13,21 @@ import (
 	"path/filepath"
 	"reflect"
 	"strconv"
+	"strings"
 	"time"
 
-	"github.com/namhq1989/demo-ai/util"
+	"github.com/namhq1989/demo-ai/httpclient"
+	"github.com/namhq1989/demo-ai/storage"
 )
 
 type TextToImagePayload struct {
-	Prompt       string `json:"prompt" form:"prompt"`
-	Mode         string `json:"mode" form:"mode"`
-	Model        string `json:"model" form:"model"`
-	AspectRatio  string `json:"aspect_ratio" form:"aspect_ratio"`
-	Seed         int    `json:"seed" form:"seed"`
-	OutputFormat string `json:"output_format" form:"output_format"`
+	Prompt         string `json:"prompt" form:"prompt"`
+	NegativePrompt string `json:"negative_prompt,omitempty" form:"negative_prompt,omitempty"`
+	Mode           string `json:"mode,omitempty" form:"mode,omitempty"`
+	Model          string `json:"model,omitempty" form:"model,omitempty"`
+	AspectRatio    string `json:"aspect_ratio" form:"aspect_ratio"`
+	Seed           int    `json:"seed" form:"seed"`
+	OutputFormat   string `json:"output_format" form:"output_format"`
 }
 
 type ImageToImagePayload struct {
@@ -53,15 +55,23 @@ func (sd StableDiffusion) TextToImage(payload TextToImagePayload) (string, error
 		return "", errors.New("invalid payload")
 	}
 
-	// random seed
-	randSource := rand.New(rand.NewSource(time.Now().Unix()))
-	payload.Seed = randSource.Intn(4294967294)
+	// random seed, unless the caller picked one
+	if payload.Seed == 0 {
+		payload.Seed = rand.Intn(4294967294)
+	}
 
 	if payload.OutputFormat == "" {
 		payload.OutputFormat = "jpeg"
 	}
 
-	payload.Mode = "text-to-image"
+	// Core and Ultra have their own endpoint, without mode and model
+	endpoint := "sd3"
+	switch payload.Model {
+	case ModelCore, ModelUltra:
+		endpoint, payload.Model = payload.Model, ""
+	default:
+		payload.Mode = "text-to-image"
+	}
 
 	// Convert struct to form data
 	b, contentType, err := structToFormData(payload)
@@ -70,7 +80,7 @@ func (sd StableDiffusion) TextToImage(payload TextToImagePayload) (string, error
 	}
 
 	// request
-	req, err := http.NewRequest("POST", "https://api.stability.ai/v2beta/stable-image/generate/sd3", b)
+	req, err := http.NewRequest("POST", "https://api.stability.ai/v2beta/stable-image/generate/"+endpoint, b)
 	if err != nil {
 		return "", err
 	}
@@ -81,21 +91,15 @@ func (sd StableDiffusion) TextToImage(payload TextToImagePayload) (string, error
 	req.Header.Set("accept", "application/json")
 
 	// execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := sd.client.Do(req)
 	if err != nil {
 		return "", fmt.Errorf("failed to execute request: %v", err)
 	}
-	defer func() { _ = resp.Body.Close() }()
 
 	// read the response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := httpclient.ReadBody(resp)
 	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("non-200 status code: %d, body: %s", resp.StatusCode, string(body))
+		return "", err
 	}
 
 	// parse the response
@@ -106,13 +110,12 @@ func (sd StableDiffusion) TextToImage(payload TextToImagePayload) (string, error
 	}
 
 	fileName := fmt.Sprintf("%d-%d.jpeg", payload.Seed, time.Now().Unix())
-	filePath := fmt.Sprintf("generated/%s", fileName)
-	err = decodeAndSaveImage(responsePayload.Image, filePath)
+	image, err := storage.SaveBase64(fileName, responsePayload.Image)
 	if err != nil {
 		return "", fmt.Errorf("failed to decode and save image: %v", err)
 	}
 
-	return util.GetImageURL(fileName), nil
+	return image.URL, nil
 }
 
 func (sd StableDiffusion) ImageToImage(payload ImageToImagePayload) (*GenerateResponse, error) {
@@ -120,9 +123,10 @@ func (sd StableDiffusion) ImageToImage(payload ImageToImagePayload) (*GenerateRe
 		return nil, errors.New("invalid payload")
 	}
 
-	// random seed
-	randSource := rand.New(rand.NewSource(time.Now().Unix()))
-	payload.Seed = randSource.Intn(4294967294)
+	// random seed, unless the caller picked one
+	if payload.Seed == 0 {
+		payload.Seed = rand.Intn(4294967294)
+	}
 
 	if payload.OutputFormat == "" {
 		payload.OutputFormat = "jpeg"
@@ -150,21 +154,15 @@ func (sd StableDiffusion) ImageToImage(payload ImageToImagePayload) (*GenerateRe
 	req.Header.Set("accept", "application/json")
 
 	// execute the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := sd.client.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %v", err)
 	}
-	defer func() { _ = resp.Body.Close() }()
 
 	// read the response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := httpclient.ReadBody(resp)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %v", err)
-	}
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("non-200 status code: %d, body: %s", resp.StatusCode, string(body))
+		return nil, err
 	}
 
 	// parse the response
@@ -174,27 +172,17 @@ func (sd StableDiffusion) ImageToImage(payload ImageToImagePayload) (*GenerateRe
 		return nil, fmt.Errorf("failed to unmarshal response: %v", err)
 	}
 
-	filePath := fmt.Sprintf("%d-%d.jpeg", payload.Seed, time.Now().Unix())
-	err = decodeAndSaveImage(responsePayload.Image, filePath)
+	fileName := fmt.Sprintf("%d-%d.jpeg", payload.Seed, time.Now().Unix())
+	image, err := storage.SaveBase64(fileName, responsePayload.Image)
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode and save image: %v", err)
 	}
 
 	return &GenerateResponse{
-		Image: filePath,
+		Image: image.URL,
 	}, nil
 }
 
-func decodeAndSaveImage(base64Image, filePath string) error {
-	rawDecodedText, _ := base64.StdEncoding.DecodeString(base64Image)
-	err := os.WriteFile(filePath, rawDecodedText, 0644) // Use appropriate file permissions
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
 func structToFormData[T any](payload T) (*bytes.Buffer, string, error) {
 	var b bytes.Buffer
 	writer := multipart.NewWriter(&b)
@@ -204,10 +192,13 @@ func structToFormData[T any](payload T) (*bytes.Buffer, string, error) {
 
 	for i := 0; i < v.NumField(); i++ {
 		field := v.Field(i)
-		tag := t.Field(i).Tag.Get("form")
+		tag, opts, _ := strings.Cut(t.Field(i).Tag.Get("form"), ",")
 		if tag == "" {
 			tag = t.Field(i).Name
 		}
+		if opts == "omitempty" && field.IsZero() {
+			continue
+		}
 
 		if tag == "image" {
 			file, err := os.Open(field.String())
@@ -1,16 +1,32 @@
 package stablediffusion
 
+import (
+	"net/http"
+
+	"github.com/namhq1989/demo-ai/httpclient"
+)
+
 type StableDiffusion struct {
 	apiKey string
+	client *http.Client
 }
 
 func NewStableDiffusion(apiKey string) StableDiffusion {
 	return StableDiffusion{
 		apiKey: apiKey,
+		client: httpclient.New(httpclient.DefaultConfig()),
 	}
 }
 
 const (
 	ModelSD3      = "sd3"
 	ModelSD3Turbo = "sd3-turbo"
+	ModelCore     = "core"
+	ModelUltra    = "ultra"
 )
+
+// ModelInpaint is the edit-image endpoint, billed as a model
+const ModelInpaint = "inpaint"
+
+// Models are the text-to-image models, Core and Ultra are served by their own endpoint
+var Models = []string{ModelSD3, ModelSD3Turbo, ModelCore, ModelUltra}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/namhq1989/demo-ai/util"
+)
+
+// Dir holds the generated and the uploaded images, it is served under /img
+const Dir = "generated"
+
+// Object is a stored image, Hash is the sha256 of its content
+type Object struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Hash string `json:"hash"`
+	Size int64  `json:"size"`
+}
+
+// Path returns the path of the image, the name cannot escape Dir
+func Path(name string) string {
+	return filepath.Join(Dir, filepath.Base(name))
+}
+
+// Save writes the image under name
+func Save(name string, data []byte) (Object, error) {
+	if err := os.WriteFile(Path(name), data, 0644); err != nil {
+		return Object{}, err
+	}
+	return newObject(name, data), nil
+}
+
+// SaveBase64 decodes the image and writes it under name
+func SaveBase64(name, b64 string) (Object, error) {
+	data, err := base64.StdEncoding.DecodeString(b64)
+	if err != nil {
+		return Object{}, fmt.Errorf("failed to decode image: %v", err)
+	}
+	return Save(name, data)
+}
+
+// SaveContent writes the image under its content hash, an image already stored is not written again
+func SaveContent(data []byte, ext string) (Object, error) {
+	sum := sha256.Sum256(data)
+	name := hex.EncodeToString(sum[:]) + ext
+
+	if _, err := os.Stat(Path(name)); err == nil {
+		return newObject(name, data), nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return Object{}, err
+	}
+	return Save(name, data)
+}
+
+func Read(name string) ([]byte, error) {
+	return os.ReadFile(Path(name))
+}
+
+// Remove deletes the image, a missing image is not an error
+func Remove(name string) error {
+	if err := os.Remove(Path(name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func newObject(name string, data []byte) Object {
+	sum := sha256.Sum256(data)
+	return Object{
+		Name: name,
+		URL:  util.GetImageURL(name),
+		Hash: hex.EncodeToString(sum[:]),
+		Size: int64(len(data)),
+	}
+}
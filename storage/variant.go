@@ -0,0 +1,169 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VariantDir caches the variants rendered from the images of Dir, see imaging.Variant
+var VariantDir = filepath.Join(Dir, "variants")
+
+// VariantCacheMaxBytes caps the size of VariantDir, the least recently used variants are evicted past it, 0 means no cap
+var VariantCacheMaxBytes int64 = 1 << 30
+
+// variantCache tracks the size of VariantDir, it is measured on the first save
+var variantCache struct {
+	mu     sync.Mutex
+	size   int64
+	loaded bool
+}
+
+// VariantName is the name of the variant of the image, the variants of an image share its base name
+func VariantName(name, key, ext string) string {
+	name = filepath.Base(name)
+	return strings.TrimSuffix(name, filepath.Ext(name)) + "." + key + ext
+}
+
+// ReadVariant reads a cached variant, a missing variant is os.ErrNotExist
+func ReadVariant(variant string) ([]byte, error) {
+	path := variantPath(variant)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the modification time is the last use, eviction goes by it
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return data, nil
+}
+
+// SaveVariant caches the variant and evicts the least recently used ones when the cache is over its cap
+func SaveVariant(variant string, data []byte) error {
+	if err := os.MkdirAll(VariantDir, 0755); err != nil {
+		return err
+	}
+
+	variantCache.mu.Lock()
+	defer variantCache.mu.Unlock()
+
+	if err := loadVariantCacheSize(); err != nil {
+		return err
+	}
+
+	path := variantPath(variant)
+	if info, err := os.Stat(path); err == nil {
+		variantCache.size -= info.Size()
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	variantCache.size += int64(len(data))
+
+	return evictVariants()
+}
+
+// RemoveVariants deletes the cached variants of the image
+func RemoveVariants(name string) error {
+	matches, err := filepath.Glob(filepath.Join(VariantDir, VariantName(name, "*", "")))
+	if err != nil {
+		return err
+	}
+
+	variantCache.mu.Lock()
+	defer variantCache.mu.Unlock()
+
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = os.Remove(match); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if variantCache.loaded {
+			variantCache.size -= info.Size()
+		}
+	}
+	return nil
+}
+
+func variantPath(variant string) string {
+	return filepath.Join(VariantDir, filepath.Base(variant))
+}
+
+func loadVariantCacheSize() error {
+	if variantCache.loaded {
+		return nil
+	}
+
+	files, err := variantFiles()
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		variantCache.size += f.size
+	}
+	variantCache.loaded = true
+	return nil
+}
+
+// evictVariants removes the least recently used variants until the cache is under 90% of its cap
+func evictVariants() error {
+	if VariantCacheMaxBytes <= 0 || variantCache.size <= VariantCacheMaxBytes {
+		return nil
+	}
+
+	files, err := variantFiles()
+	if err != nil {
+		return err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].usedAt.Before(files[j].usedAt) })
+
+	target := VariantCacheMaxBytes / 10 * 9
+	for _, f := range files {
+		if variantCache.size <= target {
+			break
+		}
+		if err = os.Remove(f.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		variantCache.size -= f.size
+	}
+	return nil
+}
+
+type variantFile struct {
+	path   string
+	size   int64
+	usedAt time.Time
+}
+
+func variantFiles() ([]variantFile, error) {
+	entries, err := os.ReadDir(VariantDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]variantFile, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		files = append(files, variantFile{path: filepath.Join(VariantDir, entry.Name()), size: info.Size(), usedAt: info.ModTime()})
+	}
+	return files, nil
+}
@@ -0,0 +1,159 @@
+package style
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// DefaultName is the preset used for the styles which are not in the registry
+const DefaultName = "default"
+
+//go:embed presets.json
+var defaultPresets []byte
+
+type Prodia struct {
+	Model    string `json:"model"`
+	Sampler  string `json:"sampler"`
+	Steps    int    `json:"steps"`
+	CFGScale int    `json:"cfgScale"`
+}
+
+type DallE struct {
+	// Style is "vivid" or "natural"
+	Style string `json:"style"`
+}
+
+type Stability struct {
+	Model string `json:"model"`
+}
+
+// Preset describes how a style is rendered by every provider
+type Preset struct {
+	Name  string `json:"name"`
+	Label string `json:"label"`
+
+	// Prompt is appended to the style name when the prompt is generated
+	Prompt         string `json:"prompt"`
+	NegativePrompt string `json:"negativePrompt"`
+
+	// Providers are used when the request does not specify any
+	Providers []string `json:"providers,omitempty"`
+
+	Prodia    Prodia    `json:"prodia"`
+	DallE     DallE     `json:"dalle"`
+	Stability Stability `json:"stability"`
+}
+
+// Describe returns the style as given to the prompt generator
+func (p Preset) Describe() string {
+	if p.Name == "" || p.Name == DefaultName {
+		return p.Prompt
+	}
+	if p.Prompt == "" {
+		return p.Name
+	}
+	return fmt.Sprintf("%s (%s)", p.Name, p.Prompt)
+}
+
+// Registry holds the style presets, it is read only once loaded
+type Registry struct {
+	presets map[string]Preset
+}
+
+// Default returns the registry of the bundled presets
+func Default() *Registry {
+	r, err := parse(defaultPresets)
+	if err != nil {
+		panic(fmt.Errorf("invalid bundled style presets: %v", err))
+	}
+	return r
+}
+
+// Load reads the presets from a JSON file, an array of presets which must contain the "default" one
+func Load(path string) (*Registry, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parse(b)
+}
+
+func parse(b []byte) (*Registry, error) {
+	var list []Preset
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, err
+	}
+
+	presets := make(map[string]Preset, len(list))
+	for _, p := range list {
+		if p.Name == "" {
+			return nil, fmt.Errorf("preset without name")
+		}
+		presets[p.Name] = p
+	}
+
+	def, ok := presets[DefaultName]
+	if !ok {
+		return nil, fmt.Errorf("missing %q preset", DefaultName)
+	}
+
+	// the settings left empty come from the default preset
+	for name, p := range presets {
+		presets[name] = p.withDefaults(def)
+	}
+
+	return &Registry{presets: presets}, nil
+}
+
+func (p Preset) withDefaults(def Preset) Preset {
+	if p.Prodia.Model == "" {
+		p.Prodia.Model = def.Prodia.Model
+	}
+	if p.Prodia.Sampler == "" {
+		p.Prodia.Sampler = def.Prodia.Sampler
+	}
+	if p.Prodia.Steps == 0 {
+		p.Prodia.Steps = def.Prodia.Steps
+	}
+	if p.Prodia.CFGScale == 0 {
+		p.Prodia.CFGScale = def.Prodia.CFGScale
+	}
+	if p.DallE.Style == "" {
+		p.DallE.Style = def.DallE.Style
+	}
+	if p.Stability.Model == "" {
+		p.Stability.Model = def.Stability.Model
+	}
+	if p.NegativePrompt == "" {
+		p.NegativePrompt = def.NegativePrompt
+	}
+	return p
+}
+
+// Get returns the preset of the style, or the default preset named after the style when it is unknown
+func (r *Registry) Get(name string) Preset {
+	if p, ok := r.presets[name]; ok {
+		return p
+	}
+
+	p := r.presets[DefaultName]
+	if name != "" {
+		p.Name, p.Label = name, name
+	}
+	return p
+}
+
+// List returns the presets sorted by name, without the default one
+func (r *Registry) List() []Preset {
+	list := make([]Preset, 0, len(r.presets))
+	for name, p := range r.presets {
+		if name != DefaultName {
+			list = append(list, p)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
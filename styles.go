@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// styleList returns the style presets the UI can offer
+func (a *app) styleList(c echo.Context) error {
+	return c.JSON(http.StatusOK, echo.Map{"styles": a.styles.List()})
+}
+
+// prodiaModels returns the SDXL models and samplers available on Prodia
+func (a *app) prodiaModels(c echo.Context) error {
+	return c.JSON(http.StatusOK, a.pd.Catalog(c.Request().Context()))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/imaging"
+	"github.com/namhq1989/demo-ai/storage"
+	"github.com/namhq1989/demo-ai/util"
+)
+
+const (
+	// textModePrompt asks the model to draw the text, textModeOverlay generates the design without text
+	// and draws the text on it with the bundled fonts
+	textModePrompt  = "prompt"
+	textModeOverlay = "overlay"
+)
+
+// overlayNegativePrompt keeps the lettering out of the base layer, on the providers which take a negative prompt
+const overlayNegativePrompt = "text, letters, words, typography, caption, watermark"
+
+func parseTextMode(s string) (string, error) {
+	switch s {
+	case "", textModePrompt:
+		return textModePrompt, nil
+	case textModeOverlay:
+		return textModeOverlay, nil
+	}
+	return "", errors.New("textMode must be prompt or overlay")
+}
+
+// overlayLayout replaces the layout of the prompt in overlay mode, the model leaves room for the text instead of drawing it
+func overlayLayout(layout string) string {
+	return fmt.Sprintf("no text or lettering at all, keep a clean empty area at the %s for a caption added later", imaging.ParsePlacement(layout).Vertical)
+}
+
+// overlayText draws the text of the history on its image, the image becomes the base layer and the composite the image of the history
+func overlayText(history database.History) (database.History, error) {
+	name := util.GetImageName(history.Name)
+	if name == "" {
+		return history, errors.New("history has no image")
+	}
+
+	data, err := storage.Read(name)
+	if err != nil {
+		return history, err
+	}
+	img, err := imaging.Decode(data)
+	if err != nil {
+		return history, err
+	}
+
+	var (
+		style     = imaging.ParseTextStyle(history.TextStyle)
+		placement = imaging.ParsePlacement(history.Layout)
+	)
+	composite, err := imaging.DrawText(img, history.Text, style, placement)
+	if err != nil {
+		return history, err
+	}
+
+	format := imaging.FormatOf(http.DetectContentType(data))
+	out, err := imaging.EncodeBytes(composite, format, 92)
+	if err != nil {
+		return history, err
+	}
+
+	object, err := storage.SaveContent(out, format.Ext())
+	if err != nil {
+		return history, err
+	}
+
+	history.TextOverlay = &database.TextOverlay{
+		Base:       history.Name,
+		Font:       string(style.Font),
+		Color:      style.Color,
+		Effect:     string(style.Effect),
+		Vertical:   placement.Vertical,
+		Horizontal: placement.Horizontal,
+	}
+	history.Name = object.URL
+	return history, nil
+}
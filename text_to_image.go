@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/namhq1989/demo-ai/breaker"
+	"github.com/namhq1989/demo-ai/database"
+	"github.com/namhq1989/demo-ai/openai"
+	"github.com/namhq1989/demo-ai/prodia"
+	"github.com/namhq1989/demo-ai/stablediffusion"
+	"github.com/namhq1989/demo-ai/style"
+	"github.com/namhq1989/demo-ai/throttle"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	maxNumOfImages = 4
+
+	// maxConcurrentGenerations bounds the provider calls running at once for a single request
+	maxConcurrentGenerations = 4
+)
+
+type textToImageInput struct {
+	Prompt             string
+	Description        string
+	Style              string
+	ColorScheme        string
+	Text               string
+	TextStyle          string
+	Layout             string
+	Theme              string
+	AdditionalElements string
+	Product            string
+
+	// TextMode is textModePrompt or textModeOverlay
+	TextMode string
+
+	GenerationID primitive.ObjectID
+	UserID       string
+	ClientIP     string
+	Seed         int
+
+	// Charged tells whether the caller pays the images with credits
+	Charged bool
+
+	// ParentID and RootID link a variation to the history it derives from
+	ParentID *primitive.ObjectID
+	RootID   *primitive.ObjectID
+
+	// PromptCost is the share of the prompt generation cost paid by every image
+	PromptCost float64
+
+	Preset   style.Preset
+	Advanced advancedParams
+}
+
+// history returns the record of the input, without the provider specific fields
+func (in textToImageInput) history() database.History {
+	return database.History{
+		ID:                 database.NewObjectID(),
+		GenerationID:       in.GenerationID,
+		ParentID:           in.ParentID,
+		RootID:             in.RootID,
+		Type:               "text-to-image",
+		Prompt:             in.Prompt,
+		Description:        in.Description,
+		Style:              in.Style,
+		ColorScheme:        in.ColorScheme,
+		Text:               in.Text,
+		TextStyle:          in.TextStyle,
+		Layout:             in.Layout,
+		Theme:              in.Theme,
+		AdditionalElements: in.AdditionalElements,
+		Product:            in.Product,
+		UserID:             in.UserID,
+		ClientIP:           in.ClientIP,
+	}
+}
+
+func (a *app) textToImage(c echo.Context) error {
+	in := textToImageInput{
+		Description:        c.QueryParam("description"),
+		Style:              c.QueryParam("style"),
+		ColorScheme:        c.QueryParam("colorScheme"),
+		Text:               c.QueryParam("text"),
+		TextStyle:          c.QueryParam("textStyle"),
+		Layout:             c.QueryParam("layout"),
+		Theme:              c.QueryParam("theme"),
+		AdditionalElements: c.QueryParam("additionalElements"),
+		Product:            c.QueryParam("product"),
+		UserID:             getCaller(c).ID,
+		ClientIP:           c.RealIP(),
+	}
+
+	var err error
+	if in.Advanced, err = parseAdvancedParams(c.QueryParam("advanced")); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+	if in.TextMode, err = parseTextMode(c.QueryParam("textMode")); err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	return a.generateImages(c, in, splitProviders(c.QueryParam("providers")))
+}
+
+// generateImages renders "n" images of the input on every provider, the prompt is generated unless the input has one
+func (a *app) generateImages(c echo.Context, in textToImageInput, requested []string) error {
+	ctx := c.Request().Context()
+
+	in.Preset = a.styles.Get(in.Style)
+
+	overlay := in.TextMode == textModeOverlay && in.Text != ""
+	if overlay {
+		in.Preset.NegativePrompt = strings.TrimPrefix(in.Preset.NegativePrompt+", "+overlayNegativePrompt, ", ")
+	}
+
+	providers, err := resolveProviders(requested, in.Preset.Providers, in.Product, textToImageProviders)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	n, err := parseNumOfImages(c.QueryParam("n"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	user := getCaller(c)
+
+	providers, err = a.applyFreeTierQuota(ctx, user, providers, n)
+	if errors.Is(err, errFreeTierQuota) {
+		return c.JSON(http.StatusForbidden, echo.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	providers, err = a.checkBudget(ctx, in.UserID, in.ClientIP, providers, func(provider string) float64 {
+		return float64(n) * textToImageCost(provider, in)
+	})
+	if errors.Is(err, errBudgetExceeded) {
+		return c.JSON(http.StatusPaymentRequired, echo.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	// every provider renders n variations
+	variationProviders := make([]string, 0, len(providers)*n)
+	for _, provider := range providers {
+		for i := 0; i < n; i++ {
+			variationProviders = append(variationProviders, provider)
+		}
+	}
+
+	in.GenerationID = database.NewObjectID()
+	in.Charged = a.usesCredits(user)
+
+	refund, err := a.chargeImages(ctx, user, "text-to-image", variationProviders, in.GenerationID)
+	if errors.Is(err, errInsufficientCredits) {
+		return c.JSON(http.StatusPaymentRequired, echo.Map{"message": err.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, echo.Map{"message": err.Error()})
+	}
+
+	if in.Prompt == "" {
+		in.PromptCost = promptCost() / float64(len(variationProviders))
+		if overlay {
+			in.Prompt = a.oa.GeneratePrompt(in.Description, in.Preset.Describe(), in.ColorScheme, "-", "-", overlayLayout(in.Layout), in.Theme, in.AdditionalElements)
+		} else {
+			in.Prompt = a.oa.GeneratePrompt(in.Description, in.Preset.Describe(), in.ColorScheme, in.Text, in.TextStyle, in.Layout, in.Theme, in.AdditionalElements)
+		}
+
+		fmt.Println("got prompt:", in.Prompt)
+	}
+
+	// each variation has its own seed
+	variations := make([]textToImageInput, len(variationProviders))
+	for i := range variations {
+		variations[i] = in
+		variations[i].Seed = rand.Intn(4294967294) + 1
+	}
+
+	var (
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, maxConcurrentGenerations)
+		images = make([]imageResult, len(variations))
+	)
+	for i := range variations {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			images[i] = a.generate(ctx, variationProviders[i], variations[i])
+		}(i)
+	}
+	wg.Wait()
+
+	refund(images)
+
+	return c.JSON(http.StatusOK, echo.Map{"generationId": in.GenerationID, "images": images})
+}
+
+func parseNumOfImages(s string) (int, error) {
+	if s == "" {
+		return 1, nil
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 || n > maxNumOfImages {
+		return 0, fmt.Errorf("n must be between 1 and %d", maxNumOfImages)
+	}
+	return n, nil
+}
+
+// generate runs text-to-image on the provider and persists the history,
+// when the breaker of the provider is open the configured alternative is used instead
+func (a *app) generate(ctx context.Context, provider string, in textToImageInput) imageResult {
+	history, ticket, err := a.runTextToImage(ctx, provider, in)
+	if errors.Is(err, breaker.ErrOpen) {
+		if alt, ok := a.failover[provider]; ok {
+			if err = a.failoverTo(ctx, provider, alt, in); err == nil {
+				fmt.Printf("[%s] circuit open, failing over to %s \n", strings.ToUpper(provider), alt)
+				provider = alt
+				history, ticket, err = a.runTextToImage(ctx, provider, in)
+			}
+		}
+	}
+
+	result := newImageResult(provider, ticket)
+
+	fmt.Printf("*** DONE %s *** %s \n", strings.ToUpper(provider), history.Name)
+
+	if err != nil {
+		fmt.Printf("[%s] error when generating image: %s \n", strings.ToUpper(provider), err.Error())
+		if errors.Is(err, errProdiaJobPending) {
+			result.Pending = true
+			result.HistoryID = history.ID.Hex()
+		}
+		return result
+	}
+
+	history = a.finishHistory(history, in.TextMode == textModeOverlay && in.Text != "")
+
+	result.URL = history.Name
+	result.HistoryID = history.ID.Hex()
+	return result
+}
+
+// finishHistory fits the image to the product, draws the text overlay, renders the thumbnail and persists the history of a generated image
+func (a *app) finishHistory(history database.History, overlay bool) database.History {
+	if err := fitToProduct(history); err != nil {
+		fmt.Printf("[%s] error when fitting image to the product: %s \n", strings.ToUpper(history.Service), err.Error())
+	}
+
+	if overlay {
+		var err error
+		if history, err = overlayText(history); err != nil {
+			fmt.Printf("[%s] error when drawing text overlay: %s \n", strings.ToUpper(history.Service), err.Error())
+		}
+	}
+
+	history.Thumbnail = thumbnailURL(history.Name)
+	a.renderThumbnail(history.Name)
+
+	// persist to db
+	if err := a.historyRepo.Create(context.Background(), history); err != nil {
+		fmt.Println("error when persisting history to db:", err.Error())
+	}
+	return history
+}
+
+// failoverTo checks the budget and the free tier quota of the alternate provider and charges the credits difference
+// with the original one, the image is not generated when the alternate provider does not fit
+func (a *app) failoverTo(ctx context.Context, provider, alt string, in textToImageInput) error {
+	if _, err := a.checkBudget(ctx, in.UserID, in.ClientIP, []string{alt}, func(p string) float64 {
+		return textToImageCost(p, in)
+	}); err != nil {
+		return fmt.Errorf("cannot fail over to %s: %w", alt, err)
+	}
+
+	if !in.Charged {
+		return nil
+	}
+
+	if alt == providerOpenAI {
+		fits, err := a.freeTierAllowsDallE(ctx, in.UserID, 1)
+		if err != nil {
+			return fmt.Errorf("cannot fail over to %s: %w", alt, err)
+		}
+		if !fits {
+			return fmt.Errorf("cannot fail over to %s: %w", alt, errFreeTierQuota)
+		}
+	}
+	if err := a.rechargeImage(ctx, in.UserID, "text-to-image", provider, alt, in.GenerationID); err != nil {
+		return fmt.Errorf("cannot fail over to %s: %w", alt, err)
+	}
+	return nil
+}
+
+func (a *app) runTextToImage(ctx context.Context, provider string, in textToImageInput) (history database.History, ticket throttle.Ticket, err error) {
+	var jobID string
+	ticket, err = a.callProvider(ctx, provider, func() error {
+		switch provider {
+		case providerStableDiffusion:
+			history, err = a.sdTextToImage(in)
+		case providerOpenAI:
+			history, err = a.oaTextToImage(in)
+		case providerProdia:
+			history, jobID, err = a.pdTextToImage(ctx, in)
+		default:
+			err = fmt.Errorf("unknown provider: %s", provider)
+		}
+		return err
+	}, func() error {
+		// the Prodia job is awaited once its vendor slot is released
+		if jobID == "" {
+			return nil
+		}
+		job := database.ProdiaJob{ID: jobID, History: history, Overlay: in.TextMode == textModeOverlay && in.Text != ""}
+		if in.Charged {
+			job.Credits = creditCost(history.Type, providerProdia)
+		}
+		history, err = a.completeProdiaJob(ctx, job)
+		return err
+	})
+	return history, ticket, err
+}
+
+func (a *app) sdTextToImage(in textToImageInput) (database.History, error) {
+	params := in.Advanced.stableDiffusion(in.Preset)
+
+	params.AspectRatio = a.sd.GetAspectRatioFromProduct(in.Product)
+	params.Seed = in.Seed
+
+	payload := stablediffusion.TextToImagePayload{
+		Prompt:         in.Prompt,
+		NegativePrompt: params.NegativePrompt,
+		Model:          params.Model,
+		AspectRatio:    params.AspectRatio,
+		Seed:           params.Seed,
+	}
+
+	url, err := a.sd.TextToImage(payload)
+	if err != nil {
+		return database.History{}, err
+	}
+
+	history := in.history()
+	history.Name = url
+	history.Service = providerStableDiffusion
+	history.AIModel = params.Model
+	history.AIConfiguration = database.AIConfiguration{StableDiffusion: &params}
+	history.Cost = textToImageCost(providerStableDiffusion, in) + in.PromptCost
+	history.CreatedAt = time.Now()
+	return history, nil
+}
+
+func (a *app) oaTextToImage(in textToImageInput) (database.History, error) {
+	params := in.Advanced.openAI(in.Preset)
+	params.Size = openai.GetSize(in.Product)
+
+	payload := openai.TextToImagePayload{
+		Prompt:         in.Prompt,
+		Model:          params.Model,
+		NumOfImages:    1,
+		ResponseFormat: "b64_json",
+		Size:           params.Size,
+		Style:          params.Style,
+		Quality:        params.Quality,
+	}
+
+	urls, err := a.oa.TextToImage(payload)
+	if err != nil {
+		return database.History{}, err
+	}
+
+	history := in.history()
+	history.Name = urls[0]
+	history.Service = providerOpenAI
+	history.AIModel = params.Model
+	history.AIConfiguration = database.AIConfiguration{OpenAI: &params}
+	history.Cost = textToImageCost(providerOpenAI, in) + in.PromptCost
+	history.CreatedAt = time.Now()
+	return history, nil
+}
+
+// pdTextToImage submits the generation, the returned history is completed once the job is done
+func (a *app) pdTextToImage(ctx context.Context, in textToImageInput) (database.History, string, error) {
+	params := in.Advanced.prodia(in.Preset)
+	params.Width, params.Height = prodia.GetSize(in.Product)
+	params.Seed = in.Seed
+
+	payload := prodia.TextToImagePayload{
+		Model:          params.Model,
+		Prompt:         in.Prompt,
+		NegativePrompt: params.NegativePrompt,
+		Steps:          params.Steps,
+		CFGScale:       params.CFGScale,
+		Sampler:        params.Sampler,
+		Width:          params.Width,
+		Height:         params.Height,
+		Seed:           params.Seed,
+	}
+
+	if err := a.pd.Validate(ctx, payload.Model, payload.Sampler); err != nil {
+		return database.History{}, "", err
+	}
+
+	history := in.history()
+	history.Service = providerProdia
+	history.AIModel = params.Model
+	history.AIConfiguration = database.AIConfiguration{Prodia: &params}
+	history.Cost = textToImageCost(providerProdia, in) + in.PromptCost
+
+	jobID, err := a.pd.SubmitTextToImage(ctx, payload)
+	if err != nil {
+		return database.History{}, "", err
+	}
+	return history, jobID, nil
+}
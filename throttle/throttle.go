@@ -0,0 +1,167 @@
+package throttle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+var ErrQueueFull = errors.New("vendor queue is full")
+
+type Config struct {
+	// MaxConcurrent is the number of calls in flight at once, 0 means no limit
+	MaxConcurrent int
+
+	// RPM is the number of calls started per minute, 0 means no limit
+	RPM int
+
+	// MaxQueue is the number of callers waiting for a slot before new ones are rejected, 0 means no limit
+	MaxQueue int
+}
+
+// Throttle limits the calls to a vendor, callers get a slot in FIFO order
+type Throttle struct {
+	name    string
+	cfg     Config
+	limiter *rate.Limiter
+
+	mu        sync.Mutex
+	active    int
+	queue     []chan struct{}
+	waited    int64
+	totalWait time.Duration
+	rejected  int64
+}
+
+// Ticket describes how a caller got its slot
+type Ticket struct {
+	// Depth is the number of callers ahead when it joined the queue
+	Depth int
+	Wait  time.Duration
+}
+
+// Status is the snapshot of a throttle, exposed in the metrics
+type Status struct {
+	Name          string  `json:"name"`
+	MaxConcurrent int     `json:"maxConcurrent"`
+	RPM           int     `json:"rpm"`
+	Active        int     `json:"active"`
+	QueueDepth    int     `json:"queueDepth"`
+	Waited        int64   `json:"waited"`
+	TotalWaitSec  float64 `json:"totalWaitSec"`
+	Rejected      int64   `json:"rejected"`
+}
+
+func New(name string, cfg Config) *Throttle {
+	t := &Throttle{name: name, cfg: cfg}
+	if cfg.RPM > 0 {
+		t.limiter = rate.NewLimiter(rate.Every(time.Minute/time.Duration(cfg.RPM)), 1)
+	}
+	return t
+}
+
+// Acquire waits for a slot and a token, release must be called once the call is done
+func (t *Throttle) Acquire(ctx context.Context) (release func(), ticket Ticket, err error) {
+	start := time.Now()
+
+	if ticket.Depth, err = t.acquireSlot(ctx); err != nil {
+		return nil, ticket, err
+	}
+
+	if t.limiter != nil {
+		if err = t.limiter.Wait(ctx); err != nil {
+			t.release()
+			return nil, ticket, err
+		}
+	}
+
+	ticket.Wait = time.Since(start)
+
+	t.mu.Lock()
+	t.waited++
+	t.totalWait += ticket.Wait
+	t.mu.Unlock()
+
+	var once sync.Once
+	return func() { once.Do(t.release) }, ticket, nil
+}
+
+// acquireSlot returns the depth of the queue when the caller joined it
+func (t *Throttle) acquireSlot(ctx context.Context) (int, error) {
+	t.mu.Lock()
+
+	if t.cfg.MaxConcurrent <= 0 || (t.active < t.cfg.MaxConcurrent && len(t.queue) == 0) {
+		t.active++
+		t.mu.Unlock()
+		return 0, nil
+	}
+
+	depth := len(t.queue)
+	if t.cfg.MaxQueue > 0 && depth >= t.cfg.MaxQueue {
+		t.rejected++
+		t.mu.Unlock()
+		return depth, ErrQueueFull
+	}
+
+	ready := make(chan struct{})
+	t.queue = append(t.queue, ready)
+	t.mu.Unlock()
+
+	select {
+	case <-ready:
+		// the slot was handed over by release
+		return depth, nil
+	case <-ctx.Done():
+		t.mu.Lock()
+		for i, ch := range t.queue {
+			if ch == ready {
+				t.queue = append(t.queue[:i], t.queue[i+1:]...)
+				t.mu.Unlock()
+				return depth, ctx.Err()
+			}
+		}
+		t.mu.Unlock()
+
+		// the slot was handed over at the same time, give it back
+		t.release()
+		return depth, ctx.Err()
+	}
+}
+
+// release hands the slot over to the first caller in the queue
+func (t *Throttle) release() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.cfg.MaxConcurrent <= 0 {
+		t.active--
+		return
+	}
+
+	if len(t.queue) > 0 {
+		next := t.queue[0]
+		t.queue = t.queue[1:]
+		close(next)
+		return
+	}
+	t.active--
+}
+
+func (t *Throttle) Status() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return Status{
+		Name:          t.name,
+		MaxConcurrent: t.cfg.MaxConcurrent,
+		RPM:           t.cfg.RPM,
+		Active:        t.active,
+		QueueDepth:    len(t.queue),
+		Waited:        t.waited,
+		TotalWaitSec:  t.totalWait.Seconds(),
+		Rejected:      t.rejected,
+	}
+}
@@ -1,10 +1,96 @@
 package util
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// ImageVariant is the variant of an image a signed url gives access to
+type ImageVariant string
+
+const (
+	// ImagePreview is the watermarked image, the unsigned urls serve it too
+	ImagePreview ImageVariant = "preview"
+
+	// ImageOriginal is the clean image, only signed urls serve it
+	ImageOriginal ImageVariant = "original"
+)
+
+var (
+	ErrInvalidImageSignature = errors.New("invalid image signature")
+	ErrImageURLExpired       = errors.New("image url expired")
+)
+
+var imageURLSecret []byte
+
+// SetImageURLSecret sets the key signing the image urls
+func SetImageURLSecret(secret string) {
+	imageURLSecret = []byte(secret)
+}
+
 func GetImageURL(name string) string {
 	return fmt.Sprintf("%s/img/%s", os.Getenv("API_HOST"), name)
 }
+
+// GetImageName returns the file name of an url built by GetImageURL, or "" when the url is not one of ours
+func GetImageName(url string) string {
+	prefix := fmt.Sprintf("%s/img/", os.Getenv("API_HOST"))
+	if !strings.HasPrefix(url, prefix) {
+		return ""
+	}
+
+	// variant urls carry the variant in the query
+	url, _, _ = strings.Cut(strings.TrimPrefix(url, prefix), "?")
+
+	name := path.Base(url)
+	if name == "." || name == "/" || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// GetSignedImageURL returns an url of the variant of the image which is valid until expires
+func GetSignedImageURL(name string, variant ImageVariant, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	q := url.Values{}
+	q.Set("v", string(variant))
+	q.Set("exp", exp)
+	q.Set("sig", signImage(name, variant, exp))
+	return GetImageURL(name) + "?" + q.Encode()
+}
+
+// VerifyImageURL checks the "v", "exp" and "sig" params of a signed url and returns the variant it gives access to
+func VerifyImageURL(name string, query url.Values) (ImageVariant, error) {
+	variant, exp, sig := ImageVariant(query.Get("v")), query.Get("exp"), query.Get("sig")
+	if variant != ImagePreview && variant != ImageOriginal {
+		return "", ErrInvalidImageSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(signImage(name, variant, exp))) {
+		return "", ErrInvalidImageSignature
+	}
+
+	unix, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return "", ErrInvalidImageSignature
+	}
+	if time.Now().Unix() > unix {
+		return "", ErrImageURLExpired
+	}
+	return variant, nil
+}
+
+func signImage(name string, variant ImageVariant, exp string) string {
+	mac := hmac.New(sha256.New, imageURLSecret)
+	_, _ = fmt.Fprintf(mac, "%s|%s|%s", path.Base(name), variant, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}